@@ -141,7 +141,14 @@ func getAuthorizerListHandler(c *gin.Context) {
 		return
 	}
 	appid := c.DefaultQuery("appid", "")
-	records, total, err := dao.GetAuthorizerRecords(appid, offset, limit)
+	health := -1
+	if value := c.DefaultQuery("health", ""); value != "" {
+		if health, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+			return
+		}
+	}
+	records, total, err := dao.GetAuthorizerRecords(appid, health, offset, limit)
 	if err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
@@ -153,9 +160,8 @@ func getAuthorizerListHandler(c *gin.Context) {
 	for i, record := range records {
 		go func(i int, record *model.Authorizer) {
 			defer wg.Done()
-			resp[i].Appid = record.Appid
-			resp[i].AuthTime = record.AuthTime
-			resp[i].RefreshToken = record.RefreshToken
+			// 拉取失败时保留原有信息 授权状态不随拉取更新
+			resp[i].Authorizer = *record
 
 			var appinfo wx.AuthorizerInfoResp
 			if err := wx.GetAuthorizerInfo(record.Appid, &appinfo); err != nil {
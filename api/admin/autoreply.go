@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+type getAutoReplyRuleListReq struct {
+	Appid  string `form:"appid"`
+	Type   int    `form:"type"`
+	Offset int    `form:"offset"`
+	Limit  int    `form:"limit"`
+}
+
+type autoReplyRule struct {
+	ID         int32               `json:"id"`
+	Name       string              `json:"name"`
+	Appid      string              `json:"appid"`
+	Type       int                 `json:"type"`
+	MatchType  int                 `json:"matchType"`
+	Keyword    string              `json:"keyword"`
+	ReplyType  int                 `json:"replyType"`
+	Info       model.AutoReplyInfo `json:"info"`
+	Open       int                 `json:"open"`
+	CreateTime int64               `json:"createTime"`
+	UpdateTime int64               `json:"updateTime"`
+}
+
+func getAutoReplyRuleListHandler(c *gin.Context) {
+	var req getAutoReplyRuleListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	records, total, err := dao.GetAutoReplyRuleList(req.Appid, req.Type, req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	res := make([]autoReplyRule, 0, len(records))
+	for _, v := range records {
+		var info model.AutoReplyInfo
+		if err := json.Unmarshal([]byte(v.Info), &info); err != nil {
+			log.Errorf("invalid autoreply info, id %d", v.ID)
+			continue
+		}
+		res = append(res, autoReplyRule{
+			ID:         v.ID,
+			Name:       v.Name,
+			Appid:      v.Appid,
+			Type:       v.Type,
+			MatchType:  v.MatchType,
+			Keyword:    v.Keyword,
+			ReplyType:  v.ReplyType,
+			Info:       info,
+			Open:       v.Open,
+			CreateTime: v.CreateTime.Unix(),
+			UpdateTime: v.UpdateTime.Unix(),
+		})
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "rules": res}))
+}
+
+func addAutoReplyRuleHandler(c *gin.Context) {
+	var req autoReplyRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	record, err := genAutoReplyRule(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if err := dao.AddAutoReplyRule(record); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+func updateAutoReplyRuleHandler(c *gin.Context) {
+	var req autoReplyRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	record, err := genAutoReplyRule(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if err := dao.UpdateAutoReplyRule(record); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+type autoReplyRuleId struct {
+	ID int32 `form:"id"`
+}
+
+func delAutoReplyRuleHandler(c *gin.Context) {
+	var req autoReplyRuleId
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if err := dao.DelAutoReplyRule(req.ID); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+// genAutoReplyRule 检查自动回复规则
+func genAutoReplyRule(req *autoReplyRule) (*model.WxAutoReplyRule, error) {
+	record := &model.WxAutoReplyRule{
+		ID:        req.ID,
+		Name:      req.Name,
+		Appid:     req.Appid,
+		Type:      req.Type,
+		MatchType: req.MatchType,
+		Keyword:   req.Keyword,
+		ReplyType: req.ReplyType,
+		Open:      req.Open,
+	}
+	if req.Appid != "" {
+		authorizer, err := dao.GetAuthorizerRecordWithCache(req.Appid)
+		if err != nil {
+			return nil, err
+		}
+		if authorizer == nil {
+			return nil, errors.New("授权账号不存在")
+		}
+		if authorizer.AppType != model.APPTYPE_MP {
+			return nil, errors.New("自动回复只支持公众号")
+		}
+	}
+	switch req.Type {
+	case model.AUTOREPLYTYPE_KEYWORD:
+		if req.Keyword == "" {
+			return nil, errors.New("关键词为空")
+		}
+		if req.MatchType < model.AUTOREPLYMATCH_EXACT || req.MatchType > model.AUTOREPLYMATCH_REGEX {
+			return nil, errors.New("匹配方式错误")
+		}
+		if err := record.Compile(); err != nil {
+			return nil, errors.New("正则格式有误")
+		}
+	case model.AUTOREPLYTYPE_SUBSCRIBE:
+		record.MatchType, record.Keyword = 0, ""
+		if req.ReplyType == model.AUTOREPLY_TRANSFER {
+			return nil, errors.New("关注回复不支持转客服")
+		}
+	default:
+		return nil, errors.New("规则类型错误")
+	}
+
+	info := model.AutoReplyInfo{}
+	switch req.ReplyType {
+	case model.AUTOREPLY_TEXT:
+		if req.Info.Content == "" {
+			return nil, errors.New("回复内容为空")
+		}
+		info.Content = req.Info.Content
+	case model.AUTOREPLY_IMAGE:
+		if req.Info.MediaId == "" {
+			return nil, errors.New("图片media_id为空")
+		}
+		info.MediaId = req.Info.MediaId
+	case model.AUTOREPLY_NEWS:
+		// 回复用户消息时只能有一条图文 关注事件最多8条
+		maxArticles := 1
+		if req.Type == model.AUTOREPLYTYPE_SUBSCRIBE {
+			maxArticles = 8
+		}
+		if len(req.Info.Articles) == 0 || len(req.Info.Articles) > maxArticles {
+			return nil, fmt.Errorf("图文消息数量需在1-%d条之间", maxArticles)
+		}
+		for _, v := range req.Info.Articles {
+			if v.Title == "" || v.Url == "" {
+				return nil, errors.New("图文消息标题或链接为空")
+			}
+		}
+		info.Articles = req.Info.Articles
+	case model.AUTOREPLY_TRANSFER:
+	default:
+		return nil, errors.New("回复类型错误")
+	}
+	value, _ := json.Marshal(info)
+	record.Info = string(value)
+	return record, nil
+}
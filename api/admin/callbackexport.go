@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/gin-gonic/gin"
+)
+
+// 消息记录导出 按id分页查询并逐页写出 大范围导出时不会一次读入内存
+
+const exportPageSize = 500
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+const (
+	EXPORTFORMAT_CSV    = "csv"
+	EXPORTFORMAT_NDJSON = "ndjson"
+)
+
+type exportWxComponentRecordsReq struct {
+	getWxComponentRecordsReq
+	Format string `form:"format"`
+}
+
+type exportWxBizRecordsReq struct {
+	getWxBizRecordsReq
+	Format string `form:"format"`
+}
+
+type recordExporter struct {
+	c      *gin.Context
+	format string
+	csv    *csv.Writer
+}
+
+func newRecordExporter(c *gin.Context, format string, name string, columns []string) (*recordExporter, error) {
+	e := &recordExporter{c: c, format: format}
+	switch format {
+	case EXPORTFORMAT_CSV:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	case EXPORTFORMAT_NDJSON:
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	default:
+		return nil, errors.New("导出格式错误")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.%s", name,
+		time.Now().Format("20060102150405"), format))
+	c.Status(http.StatusOK)
+	if format == EXPORTFORMAT_CSV {
+		// 带BOM以便excel识别utf8
+		c.Writer.WriteString("\xEF\xBB\xBF")
+		e.csv = csv.NewWriter(c.Writer)
+		e.csv.Write(columns)
+	}
+	return e, nil
+}
+
+// write csv写入row ndjson写入record的json
+func (e *recordExporter) write(record interface{}, row []string) error {
+	if e.format == EXPORTFORMAT_CSV {
+		return e.csv.Write(row)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = e.c.Writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *recordExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	e.c.Writer.Flush()
+	return nil
+}
+
+func exportWxComponentRecordsHandler(c *gin.Context) {
+	var req exportWxComponentRecordsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	filter, err := genComponentRecordFilter(&req.getWxComponentRecordsReq)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	exporter, err := newRecordExporter(c, req.Format, "wx-component-records",
+		[]string{"id", "receiveTime", "createTime", "infoType", "dupCount", "postBody"})
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	// 响应头已发出 出错时只能中断输出
+	var afterId int64
+	for {
+		records, err := dao.GetComponentCallBackRecordsAfter(filter, afterId, exportPageSize)
+		if err != nil {
+			log.Errorf("export component records err %v", err)
+			return
+		}
+		for _, v := range records {
+			if err := exporter.write(v, []string{strconv.FormatInt(v.ID, 10),
+				v.ReceiveTime.Format(exportTimeLayout), v.CreateTime.Format(exportTimeLayout),
+				v.InfoType, strconv.Itoa(v.DupCount), v.PostBody}); err != nil {
+				log.Errorf("export component records err %v", err)
+				return
+			}
+		}
+		if err := exporter.flush(); err != nil {
+			log.Errorf("export component records err %v", err)
+			return
+		}
+		if len(records) < exportPageSize {
+			return
+		}
+		afterId = records[len(records)-1].ID
+	}
+}
+
+func exportWxBizRecordsHandler(c *gin.Context) {
+	var req exportWxBizRecordsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	filter, err := genBizRecordFilter(&req.getWxBizRecordsReq)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	exporter, err := newRecordExporter(c, req.Format, "wx-biz-records",
+		[]string{"id", "receiveTime", "createTime", "appid", "toUserName", "fromUserName", "msgType",
+			"event", "dupCount", "postBody"})
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	// 响应头已发出 出错时只能中断输出
+	var afterId int64
+	for {
+		records, err := dao.GetBizCallBackRecordsAfter(filter, afterId, exportPageSize)
+		if err != nil {
+			log.Errorf("export biz records err %v", err)
+			return
+		}
+		for _, v := range records {
+			if err := exporter.write(v, []string{strconv.FormatInt(v.ID, 10),
+				v.ReceiveTime.Format(exportTimeLayout), v.CreateTime.Format(exportTimeLayout),
+				v.Appid, v.ToUserName, v.FromUserName, v.MsgType, v.Event, strconv.Itoa(v.DupCount),
+				v.PostBody}); err != nil {
+				log.Errorf("export biz records err %v", err)
+				return
+			}
+		}
+		if err := exporter.flush(); err != nil {
+			log.Errorf("export biz records err %v", err)
+			return
+		}
+		if len(records) < exportPageSize {
+			return
+		}
+		afterId = records[len(records)-1].ID
+	}
+}
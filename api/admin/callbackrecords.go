@@ -1,9 +1,12 @@
 package admin
 
 import (
+	"errors"
 	"net/http"
+	"regexp"
 	"time"
 
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
 	wxbase "github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/base"
 
@@ -11,22 +14,79 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// recordSearchReq 按消息内容搜索 jsonField为消息中的字段 嵌套字段用.分隔
+type recordSearchReq struct {
+	Keyword   string `form:"keyword"`
+	JsonField string `form:"jsonField"`
+	JsonValue string `form:"jsonValue"`
+}
+
 type getWxComponentRecordsReq struct {
 	StartTime int64  `form:"startTime"`
 	EndTime   int64  `form:"endTime"`
 	InfoType  string `form:"infoType"`
-	Offset    int    `form:"offset"`
-	Limit     int    `form:"limit"`
+	recordSearchReq
+	Offset int `form:"offset"`
+	Limit  int `form:"limit"`
 }
 
 type getWxBizRecordsReq struct {
-	StartTime int64  `form:"startTime"`
-	EndTime   int64  `form:"endTime"`
-	Appid     string `form:"appid"`
-	MsgType   string `form:"msgType"`
-	Event     string `form:"event"`
-	Offset    int    `form:"offset"`
-	Limit     int    `form:"limit"`
+	StartTime    int64  `form:"startTime"`
+	EndTime      int64  `form:"endTime"`
+	Appid        string `form:"appid"`
+	MsgType      string `form:"msgType"`
+	Event        string `form:"event"`
+	FromUserName string `form:"fromUserName"`
+	recordSearchReq
+	Offset int `form:"offset"`
+	Limit  int `form:"limit"`
+}
+
+var jsonFieldRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+func (req *recordSearchReq) check() error {
+	if req.JsonField != "" && !jsonFieldRegexp.MatchString(req.JsonField) {
+		return errors.New("jsonField格式有误")
+	}
+	return nil
+}
+
+func genEndTime(endTime int64) time.Time {
+	if endTime == 0 {
+		return time.Now()
+	}
+	return time.Unix(endTime, 0)
+}
+
+func genComponentRecordFilter(req *getWxComponentRecordsReq) (*dao.ComponentRecordFilter, error) {
+	if err := req.check(); err != nil {
+		return nil, err
+	}
+	return &dao.ComponentRecordFilter{
+		StartTime: time.Unix(req.StartTime, 0),
+		EndTime:   genEndTime(req.EndTime),
+		InfoType:  req.InfoType,
+		Keyword:   req.Keyword,
+		JsonField: req.JsonField,
+		JsonValue: req.JsonValue,
+	}, nil
+}
+
+func genBizRecordFilter(req *getWxBizRecordsReq) (*dao.BizRecordFilter, error) {
+	if err := req.check(); err != nil {
+		return nil, err
+	}
+	return &dao.BizRecordFilter{
+		StartTime:    time.Unix(req.StartTime, 0),
+		EndTime:      genEndTime(req.EndTime),
+		Appid:        req.Appid,
+		MsgType:      req.MsgType,
+		Event:        req.Event,
+		FromUserName: req.FromUserName,
+		Keyword:      req.Keyword,
+		JsonField:    req.JsonField,
+		JsonValue:    req.JsonValue,
+	}, nil
 }
 
 func getWxComponentRecordsHandler(c *gin.Context) {
@@ -35,19 +95,22 @@ func getWxComponentRecordsHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
-	var endTime time.Time
-	if req.EndTime == 0 {
-		endTime = time.Now()
-	} else {
-		endTime = time.Unix(req.EndTime, 0)
+	filter, err := genComponentRecordFilter(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
 	}
-	records, total, err := dao.GetComponentCallBackRecordList(time.Unix(req.StartTime, 0),
-		endTime, req.InfoType, req.Offset, req.Limit)
+	records, total, err := dao.GetComponentCallBackRecordList(filter, req.Offset, req.Limit)
 	if err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
-	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "records": records}))
+	dupTotal, err := dao.GetComponentCallBackDupTotal(filter)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "dupTotal": dupTotal, "records": records}))
 }
 
 func getWxBizRecordsHandler(c *gin.Context) {
@@ -56,14 +119,48 @@ func getWxBizRecordsHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
+	filter, err := genBizRecordFilter(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	records, total, err := dao.GetBizCallBackRecordList(filter, req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	dupTotal, err := dao.GetBizCallBackDupTotal(filter)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "dupTotal": dupTotal, "records": records}))
+}
+
+type getCallBackDeliveryRecordsReq struct {
+	StartTime int64  `form:"startTime"`
+	EndTime   int64  `form:"endTime"`
+	RuleId    int32  `form:"ruleId"`
+	Appid     string `form:"appid"`
+	Result    int    `form:"result"`
+	Offset    int    `form:"offset"`
+	Limit     int    `form:"limit"`
+}
+
+func getCallBackDeliveryRecordsHandler(c *gin.Context) {
+	var req getCallBackDeliveryRecordsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
 	var endTime time.Time
 	if req.EndTime == 0 {
 		endTime = time.Now()
 	} else {
 		endTime = time.Unix(req.EndTime, 0)
 	}
-	records, total, err := dao.GetBizCallBackRecordList(time.Unix(req.StartTime, 0),
-		endTime, req.Appid, req.MsgType, req.Event, req.Offset, req.Limit)
+	records, total, err := dao.GetCallBackDeliveryRecordList(time.Unix(req.StartTime, 0),
+		endTime, req.RuleId, req.Appid, req.Result, req.Offset, req.Limit)
 	if err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
@@ -72,11 +169,15 @@ func getWxBizRecordsHandler(c *gin.Context) {
 }
 
 func getWxCallBackConfigHandler(c *gin.Context) {
+	textMode := "json"
+	if config.WxCallbackConf.EncodingAESKey != "" {
+		textMode = "xml"
+	}
 	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
 		"envId":         wxbase.GetEnvId(),
 		"service":       wxbase.GetService(),
 		"componentPath": "/wxcallback/component",
 		"bizPath":       "/wxcallback/biz/$APPID$",
-		"textMode":      "json",
+		"textMode":      textMode,
 	}))
 }
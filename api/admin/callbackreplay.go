@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/wxcallback"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+const maxReplayLimit = 100
+
+// 重放任务的进度保存在comm表中 多实例部署时任一实例都可查询
+const replayJobKeyPrefix = "callback_replay_job_"
+const replayJobExpire = 24 * time.Hour
+
+// 每重放replayJobSaveEvery条保存一次进度
+const replayJobSaveEvery = 10
+
+// 重放任务的状态
+const (
+	REPLAYJOB_RUNNING = "running"
+	REPLAYJOB_DONE    = "done"
+	REPLAYJOB_FAIL    = "fail"
+)
+
+type replayCallBackReq struct {
+	Type      int    `json:"type"`
+	ID        int64  `json:"id"`
+	StartTime int64  `json:"startTime"`
+	EndTime   int64  `json:"endTime"`
+	Appid     string `json:"appid"`
+	InfoType  string `json:"infoType"`
+	MsgType   string `json:"msgType"`
+	Event     string `json:"event"`
+	AfterId   int64  `json:"afterId"`
+	Limit     int    `json:"limit"`
+}
+
+// replayItem 待重放的消息 授权事件没有appid、msgType和event
+type replayItem struct {
+	id       int64
+	appid    string
+	infoType string
+	msgType  string
+	event    string
+	body     string
+}
+
+// replayJob 后台重放任务
+type replayJob struct {
+	ID        string                     `json:"id"`
+	Status    string                     `json:"status"`
+	Total     int                        `json:"total"`
+	Done      int                        `json:"done"`
+	LastId    int64                      `json:"lastId"`
+	ErrMsg    string                     `json:"errMsg,omitempty"`
+	StartTime int64                      `json:"startTime"`
+	EndTime   int64                      `json:"endTime"`
+	Results   []*wxcallback.ReplayResult `json:"results"`
+}
+
+// replayCallBackRecordsHandler 按当前规则重放消息 指定id时同步重放单条
+// 否则按条件取出最多maxReplayLimit条 在后台重放并返回任务id 返回的lastId作为下一批的afterId
+func replayCallBackRecordsHandler(c *gin.Context) {
+	var req replayCallBackReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.Type != model.CALLBACKTYPE_COM && req.Type != model.CALLBACKTYPE_BIZ {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("消息类型错误"))
+		return
+	}
+	if req.ID == 0 && req.StartTime == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("请指定消息id或开始时间"))
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxReplayLimit {
+		req.Limit = maxReplayLimit
+	}
+	items, err := getReplayItems(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	var lastId int64
+	if len(items) != 0 {
+		lastId = items[len(items)-1].id
+	}
+
+	if req.ID != 0 {
+		results := make([]*wxcallback.ReplayResult, 0, len(items))
+		for _, v := range items {
+			results = append(results, replayOne(v))
+		}
+		c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+			"total":   len(results),
+			"lastId":  lastId,
+			"results": results,
+		}))
+		return
+	}
+
+	job := &replayJob{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		Status:    REPLAYJOB_RUNNING,
+		Total:     len(items),
+		LastId:    lastId,
+		StartTime: time.Now().Unix(),
+		Results:   make([]*wxcallback.ReplayResult, 0, len(items)),
+	}
+	if err := saveReplayJob(job); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	go runReplayJob(job, items)
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+		"jobId":  job.ID,
+		"total":  job.Total,
+		"lastId": lastId,
+	}))
+}
+
+// getReplayItems 按请求取出待重放的消息
+func getReplayItems(req *replayCallBackReq) ([]*replayItem, error) {
+	endTime := time.Now()
+	if req.EndTime != 0 {
+		endTime = time.Unix(req.EndTime, 0)
+	}
+	var items []*replayItem
+	if req.Type == model.CALLBACKTYPE_COM {
+		var records []*model.WxCallbackComponentRecord
+		if req.ID != 0 {
+			record, err := dao.GetComponentCallBackRecordById(req.ID)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		} else {
+			var err error
+			filter := &dao.ComponentRecordFilter{
+				StartTime: time.Unix(req.StartTime, 0),
+				EndTime:   endTime,
+				InfoType:  req.InfoType,
+			}
+			if records, err = dao.GetComponentCallBackRecordsAfter(filter, req.AfterId, req.Limit); err != nil {
+				return nil, err
+			}
+		}
+		for _, v := range records {
+			items = append(items, &replayItem{id: v.ID, infoType: v.InfoType, body: v.PostBody})
+		}
+		return items, nil
+	}
+	var records []*model.WxCallbackBizRecord
+	if req.ID != 0 {
+		record, err := dao.GetBizCallBackRecordById(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	} else {
+		var err error
+		filter := &dao.BizRecordFilter{
+			StartTime: time.Unix(req.StartTime, 0),
+			EndTime:   endTime,
+			Appid:     req.Appid,
+			MsgType:   req.MsgType,
+			Event:     req.Event,
+		}
+		if records, err = dao.GetBizCallBackRecordsAfter(filter, req.AfterId, req.Limit); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range records {
+		items = append(items, &replayItem{id: v.ID, appid: v.Appid, msgType: v.MsgType, event: v.Event,
+			body: v.PostBody})
+	}
+	return items, nil
+}
+
+func replayOne(item *replayItem) *wxcallback.ReplayResult {
+	return wxcallback.ReplayCallbackMsg(item.id, item.appid, item.infoType, item.msgType, item.event, item.body)
+}
+
+// runReplayJob 在后台逐条重放 定期保存进度
+func runReplayJob(job *replayJob, items []*replayItem) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("replay job %s panic: %v", job.ID, err)
+			job.Status, job.ErrMsg = REPLAYJOB_FAIL, fmt.Sprint(err)
+			job.EndTime = time.Now().Unix()
+			saveReplayJob(job)
+		}
+	}()
+	for i, v := range items {
+		job.Results = append(job.Results, replayOne(v))
+		job.Done = i + 1
+		if job.Done%replayJobSaveEvery == 0 && job.Done != job.Total {
+			saveReplayJob(job)
+		}
+	}
+	job.Status = REPLAYJOB_DONE
+	job.EndTime = time.Now().Unix()
+	saveReplayJob(job)
+	dao.DelExpiredCommKvWithPrefix(replayJobKeyPrefix, replayJobExpire)
+}
+
+func saveReplayJob(job *replayJob) error {
+	value, _ := json.Marshal(job)
+	return dao.SetCommKv(replayJobKeyPrefix+job.ID, string(value))
+}
+
+type getReplayJobReq struct {
+	ID string `form:"id"`
+}
+
+// getCallBackReplayJobHandler 查询后台重放任务的进度和结果
+func getCallBackReplayJobHandler(c *gin.Context) {
+	var req getReplayJobReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	value := dao.GetCommKv(replayJobKeyPrefix+req.ID, "")
+	if req.ID == "" || value == "" {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("重放任务不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(json.RawMessage(value)))
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/utils"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// getOperator 当前登录的管理员用户名
+func getOperator(c *gin.Context) string {
+	if jwt, ok := c.Get("jwt"); ok {
+		if claims, ok := jwt.(*utils.Claims); ok {
+			return claims.UserName
+		}
+	}
+	return ""
+}
+
+type getCallBackRuleHistoryReq struct {
+	ID     int32 `form:"id"`
+	Offset int   `form:"offset"`
+	Limit  int   `form:"limit"`
+}
+
+func getCallBackRuleHistoryHandler(c *gin.Context) {
+	var req getCallBackRuleHistoryReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.ID == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("规则id为空"))
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	records, total, err := dao.GetWxCallBackRuleHistory(req.ID, req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+		"total":   total,
+		"records": records,
+	}))
+}
+
+type restoreCallBackRuleReq struct {
+	ID      int32 `json:"id"`
+	Version int   `json:"version"`
+	Before  bool  `json:"before"` // 恢复为该版本变更前的内容 用于撤销某次变更或删除
+}
+
+// restoreCallBackRuleHandler 把规则恢复为某个版本变更后(或变更前)的内容 恢复本身也会记录为新版本
+func restoreCallBackRuleHandler(c *gin.Context) {
+	var req restoreCallBackRuleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.ID == 0 || req.Version == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("规则id或版本为空"))
+		return
+	}
+	history, err := dao.GetWxCallBackRuleHistoryVersion(req.ID, req.Version)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("版本不存在, "+err.Error()))
+		return
+	}
+	snapshot := history.AfterRule
+	if req.Before {
+		snapshot = history.BeforeRule
+	}
+	if snapshot == "" {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("该版本没有可恢复的内容"))
+		return
+	}
+	rule, err := model.ParseRuleSnapshot(snapshot)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	rule.ID = req.ID
+	if exist, err := dao.HasSameWxCallBackRule(rule); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	} else if exist {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("该事件已存在转发规则"))
+		return
+	}
+	if err := dao.RestoreWxCallBackRule(rule, req.Version, getOperator(c)); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
@@ -2,13 +2,15 @@ package admin
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
-	"time"
 
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/wxcallback"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
-	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/httputils"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
@@ -27,10 +29,18 @@ type callBackProxyRule struct {
 	InfoType   string                `json:"infoType"`
 	MsgType    string                `json:"msgType"`
 	Event      string                `json:"event"`
+	Appids     []string              `json:"appids"`
+	Priority   int                   `json:"priority"`
 	Open       int                   `json:"open"`
-	Data       model.HttpProxyConfig `json:"data"`
-	CreateTime int64                 `json:"createTime"`
-	UpdateTime int64                 `json:"updateTime"`
+	Type       int                   `json:"type"`
+	Async      int                   `json:"async"`
+	Conditions []model.RuleCondition `json:"conditions"`
+	Transform  *model.RuleTransform  `json:"transform,omitempty"`
+	// 等待回包的期限 单位毫秒 为0时使用默认配置 为-1时一直等待
+	ReplyDeadline int             `json:"replyDeadline"`
+	Data          json.RawMessage `json:"data"`
+	CreateTime    int64           `json:"createTime"`
+	UpdateTime    int64           `json:"updateTime"`
 }
 
 func getCallBackProxyRuleListHandler(c *gin.Context) {
@@ -46,20 +56,35 @@ func getCallBackProxyRuleListHandler(c *gin.Context) {
 	}
 	res := make([]callBackProxyRule, 0, 10)
 	for _, v := range dbValue {
-		var proxyConfig model.HttpProxyConfig
-		if err = json.Unmarshal([]byte(v.Info), &proxyConfig); err != nil {
-			log.Errorf("Unmarshal err, %v", err)
+		if !json.Valid([]byte(v.Info)) {
+			log.Errorf("invalid rule info, id %d", v.ID)
 		} else {
+			conditions := []model.RuleCondition{}
+			if v.Conditions != "" {
+				json.Unmarshal([]byte(v.Conditions), &conditions)
+			}
+			var transform *model.RuleTransform
+			if v.Transform != "" {
+				transform = &model.RuleTransform{}
+				json.Unmarshal([]byte(v.Transform), transform)
+			}
 			res = append(res, callBackProxyRule{
-				ID:         v.ID,
-				Name:       v.Name,
-				InfoType:   v.InfoType,
-				MsgType:    v.MsgType,
-				Event:      v.Event,
-				Open:       v.Open,
-				Data:       proxyConfig,
-				CreateTime: v.CreateTime.Unix(),
-				UpdateTime: v.UpdateTime.Unix(),
+				ID:            v.ID,
+				Name:          v.Name,
+				InfoType:      v.InfoType,
+				MsgType:       v.MsgType,
+				Event:         v.Event,
+				Appids:        v.GetAppids(),
+				Priority:      v.Priority(),
+				Open:          v.Open,
+				Type:          v.Type,
+				Async:         v.Async,
+				Conditions:    conditions,
+				Transform:     transform,
+				ReplyDeadline: v.ReplyDeadline,
+				Data:          json.RawMessage(model.MaskRuleInfo(v.Type, v.Info)),
+				CreateTime:    v.CreateTime.Unix(),
+				UpdateTime:    v.UpdateTime.Unix(),
 			})
 		}
 	}
@@ -75,21 +100,29 @@ func updateCallBackProxyRuleHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
-	if req.InfoType == "" && req.MsgType == "" && req.Event == "" {
-		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("消息推送类型为空"))
-		return
-	}
-	value, _ := json.Marshal(req.Data)
-	if err := dao.UpdateWxCallBackRule(&model.WxCallbackRule{
-		ID:       req.ID,
-		Name:     req.Name,
-		InfoType: req.InfoType,
-		MsgType:  req.MsgType,
-		Event:    req.Event,
-		Open:     req.Open,
-		Type:     model.PROXYTYPE_HTTP,
-		Info:     string(value),
-	}); err != nil {
+	rule, err := genCallBackRule(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	rule.ID = req.ID
+	existing, err := dao.GetWxCallBackRuleById(rule.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	if rule.Info, err = model.RestoreMaskedRuleInfo(rule.Type, rule.Info, existing); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if exist, err := dao.HasSameWxCallBackRule(rule); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	} else if exist {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("该事件已存在转发规则"))
+		return
+	}
+	if err := dao.UpdateWxCallBackRule(rule, getOperator(c)); err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
@@ -102,30 +135,239 @@ func addCallBackProxyRuleHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
-	if req.InfoType == "" && req.MsgType == "" && req.Event == "" {
-		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("消息推送类型为空"))
-		return
-	}
-	value, _ := json.Marshal(req.Data)
-	if err := dao.AddWxCallBackRule(&model.WxCallbackRule{
-		Name:     req.Name,
-		InfoType: req.InfoType,
-		MsgType:  req.MsgType,
-		Event:    req.Event,
-		Open:     req.Open,
-		Type:     model.PROXYTYPE_HTTP,
-		Info:     string(value),
-	}); err != nil {
-		if strings.Contains(err.Error(), "Error 1062") {
-			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("该事件已存在转发规则"))
-			return
-		}
+	rule, err := genCallBackRule(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	// 新规则没有可沿用的密钥
+	if rule.Info, err = model.RestoreMaskedRuleInfo(rule.Type, rule.Info, nil); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if exist, err := dao.HasSameWxCallBackRule(rule); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	} else if exist {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("该事件已存在转发规则"))
+		return
+	}
+	if err := dao.AddWxCallBackRule(rule, getOperator(c)); err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
 	c.JSON(http.StatusOK, errno.OK)
 }
 
+// maxReplyDeadline 回复期限的上限 需给网关回复微信留出时间 单位毫秒
+const maxReplyDeadline = 4500
+
+// genCallBackRule 检查规则的各项配置并生成规则 不包含id
+func genCallBackRule(req *callBackProxyRule) (*model.WxCallbackRule, error) {
+	appids, err := checkCallBackRuleMatch(req)
+	if err != nil {
+		return nil, err
+	}
+	value, err := genCallBackRuleInfo(req)
+	if err != nil {
+		return nil, err
+	}
+	conditions, err := genCallBackRuleConditions(req.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	transform, err := genCallBackRuleTransform(req.Transform)
+	if err != nil {
+		return nil, err
+	}
+	if req.Async != 0 && !wxcallback.AsyncEnabled() {
+		return nil, errors.New("未启用异步转发 需配置AsyncWorkers大于0")
+	}
+	if req.ReplyDeadline < -1 || req.ReplyDeadline > maxReplyDeadline {
+		return nil, fmt.Errorf("回复期限需在%d毫秒以内 -1为一直等待", maxReplyDeadline)
+	}
+	return &model.WxCallbackRule{
+		Name:          req.Name,
+		InfoType:      req.InfoType,
+		MsgType:       req.MsgType,
+		Event:         req.Event,
+		Appids:        appids,
+		Open:          req.Open,
+		Type:          req.Type,
+		Async:         req.Async,
+		Info:          value,
+		Conditions:    conditions,
+		Transform:     transform,
+		ReplyDeadline: req.ReplyDeadline,
+	}, nil
+}
+
+// checkCallBackRuleMatch 检查匹配条件 返回去重排序后的appid列表
+func checkCallBackRuleMatch(req *callBackProxyRule) (string, error) {
+	if req.InfoType == "" && req.MsgType == "" && req.Event == "" {
+		return "", errors.New("消息推送类型为空")
+	}
+	if req.InfoType != "" && (req.MsgType != "" || req.Event != "") {
+		return "", errors.New("授权事件不能同时配置消息类型")
+	}
+	if req.InfoType == "" && req.MsgType == "" {
+		return "", errors.New("消息类型为空 匹配所有消息类型请填*")
+	}
+	appidMap := make(map[string]bool)
+	appids := make([]string, 0, len(req.Appids))
+	for _, v := range req.Appids {
+		v = strings.TrimSpace(v)
+		if v == "" || appidMap[v] {
+			continue
+		}
+		if strings.Contains(v, ",") {
+			return "", errors.New("appid格式有误")
+		}
+		appidMap[v] = true
+		appids = append(appids, v)
+	}
+	if len(appids) != 0 && req.InfoType != "" {
+		return "", errors.New("授权事件不支持按appid转发")
+	}
+	sort.Strings(appids)
+	value := strings.Join(appids, ",")
+	if len(value) > 2048 {
+		return "", errors.New("appid数量过多")
+	}
+	return value, nil
+}
+
+// genCallBackRuleConditions 检查内容匹配条件 没有条件时返回空字符串
+func genCallBackRuleConditions(conditions []model.RuleCondition) (string, error) {
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	for i := range conditions {
+		if err := conditions[i].Compile(); err != nil {
+			return "", err
+		}
+	}
+	value, _ := json.Marshal(conditions)
+	if len(value) > 4096 {
+		return "", errors.New("匹配条件过长")
+	}
+	return string(value), nil
+}
+
+// genCallBackRuleTransform 检查改写配置 未配置时返回空字符串
+func genCallBackRuleTransform(transform *model.RuleTransform) (string, error) {
+	if transform == nil || transform.Type == "" {
+		return "", nil
+	}
+	if err := transform.Compile(); err != nil {
+		return "", err
+	}
+	value, _ := json.Marshal(transform)
+	if len(value) > 16384 {
+		return "", errors.New("改写配置过长")
+	}
+	return string(value), nil
+}
+
+// genCallBackRuleInfo 按规则类型检查并生成转发配置
+func genCallBackRuleInfo(req *callBackProxyRule) (string, error) {
+	var value []byte
+	switch req.Type {
+	case 0:
+		req.Type = model.PROXYTYPE_HTTP
+		fallthrough
+	case model.PROXYTYPE_HTTP:
+		var proxyConfig model.HttpProxyConfig
+		if err := json.Unmarshal(req.Data, &proxyConfig); err != nil {
+			return "", err
+		}
+		if err := checkProxyTargets(&proxyConfig); err != nil {
+			return "", err
+		}
+		value, _ = json.Marshal(proxyConfig)
+	case model.PROXYTYPE_WEBHOOK:
+		var webhook model.WebhookConfig
+		if err := json.Unmarshal(req.Data, &webhook); err != nil {
+			return "", err
+		}
+		if err := checkWebhook(&webhook); err != nil {
+			return "", err
+		}
+		value, _ = json.Marshal(webhook)
+	case model.PROXYTYPE_FILE:
+		var config model.FileSinkConfig
+		if err := json.Unmarshal(req.Data, &config); err != nil {
+			return "", err
+		}
+		if err := config.Check(); err != nil {
+			return "", err
+		}
+		if err := wxcallback.CheckFileSinkPath(strings.Replace(config.Path, "$APPID$", "wxtestappid", -1)); err != nil {
+			return "", err
+		}
+		value, _ = json.Marshal(config)
+	case model.PROXYTYPE_COMMAND:
+		var config model.CommandSinkConfig
+		if err := json.Unmarshal(req.Data, &config); err != nil {
+			return "", err
+		}
+		if err := config.Check(); err != nil {
+			return "", err
+		}
+		if err := wxcallback.CheckSinkCommand(config.Command); err != nil {
+			return "", err
+		}
+		value, _ = json.Marshal(config)
+	case model.PROXYTYPE_MQ:
+		var config model.MQSinkConfig
+		if err := json.Unmarshal(req.Data, &config); err != nil {
+			return "", err
+		}
+		if err := config.Check(); err != nil {
+			return "", err
+		}
+		if !wxcallback.HasMQDriver(config.Driver) {
+			return "", fmt.Errorf("消息队列%s未注册", config.Driver)
+		}
+		value, _ = json.Marshal(config)
+	default:
+		return "", errors.New("转发类型错误")
+	}
+	return string(value), nil
+}
+
+// checkWebhook 检查webhook配置
+func checkWebhook(webhook *model.WebhookConfig) error {
+	target, err := url.Parse(strings.Replace(webhook.Url, "$APPID$", "wxtestappid", -1))
+	if err != nil {
+		return err
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("webhook地址格式有误")
+	}
+	if webhook.Timeout < 0 || webhook.Timeout > 30000 {
+		return errors.New("超时时间需在30000毫秒以内")
+	}
+	return nil
+}
+
+// checkProxyTargets 检查转发目标 最多只能有一个主目标
+func checkProxyTargets(proxyConfig *model.HttpProxyConfig) error {
+	primaryCount := 0
+	for _, v := range proxyConfig.Targets {
+		if v.Port == 0 {
+			return errors.New("转发目标端口为空")
+		}
+		if v.Primary {
+			primaryCount++
+		}
+	}
+	if primaryCount > 1 {
+		return errors.New("只能有一个主转发目标")
+	}
+	return nil
+}
+
 type callBackProxyRuleId struct {
 	ID int32 `form:"id"`
 }
@@ -136,72 +378,85 @@ func delCallBackProxyRuleHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
-	if err := dao.DelWxCallBackRule(req.ID); err != nil {
+	if err := dao.DelWxCallBackRule(req.ID, getOperator(c)); err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
 	c.JSON(http.StatusOK, errno.OK)
 }
 
+type testCallbackRuleReq struct {
+	ID       int32           `json:"id"`
+	Appid    string          `json:"appid"`    // 为空时取消息记录的appid或规则限定的第一个appid
+	Payload  json.RawMessage `json:"payload"`  // 自定义测试消息
+	Type     int             `json:"type"`     // 使用已记录的消息 1为授权事件 2为消息与事件
+	RecordID int64           `json:"recordId"` // 消息记录id
+	Sample   string          `json:"sample"`   // 内置示例 见/admin/callback-test-samples
+}
+
+// testCallbackRuleHandler 测试转发规则 测试消息依次取payload、消息记录、内置示例 都为空时按规则生成
 func testCallbackRuleHandler(c *gin.Context) {
-	var req callBackProxyRuleId
+	var req testCallbackRuleReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
-	if record, err := dao.GetWxCallBackRuleById(req.ID); err != nil {
+	record, err := dao.GetWxCallBackRuleById(req.ID)
+	if err != nil {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
-	} else {
-		if record.Open != 0 && record.Type == model.PROXYTYPE_HTTP {
-			var proxyConfig model.HttpProxyConfig
-			if err = json.Unmarshal([]byte(record.Info), &proxyConfig); err != nil {
-				log.Errorf("Unmarshal err, %v", err)
+	}
+	payload, appid := []byte(req.Payload), req.Appid
+	if len(payload) == 0 && req.RecordID != 0 {
+		switch req.Type {
+		case model.CALLBACKTYPE_COM:
+			v, err := dao.GetComponentCallBackRecordById(req.RecordID)
+			if err != nil {
 				c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 				return
 			}
-			resp, err := httputils.PostJson(fmt.Sprintf("http://127.0.0.1:%d%s", proxyConfig.Port,
-				strings.Replace(proxyConfig.Path, "$APPID$", "wxtestappid", -1)),
-				genWxCallBackReq(record))
+			payload = []byte(v.PostBody)
+		case model.CALLBACKTYPE_BIZ:
+			v, err := dao.GetBizCallBackRecordById(req.RecordID)
 			if err != nil {
-				log.Error(err)
-				c.JSON(http.StatusOK, errno.ErrRequestErr.WithData(err.Error()))
+				c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 				return
 			}
-			c.JSON(http.StatusOK, errno.OK.WithData(string(resp)))
-			return
-		} else {
-			c.JSON(http.StatusOK, errno.ErrInvalidStatus.WithData("该规则未启用或类型异常"))
+			payload = []byte(v.PostBody)
+			if appid == "" {
+				appid = v.Appid
+			}
+		default:
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("消息类型错误"))
 			return
 		}
 	}
-}
-
-type wxCallBackReq struct {
-	CreateTime   int64  `json:"CreateTime"`
-	ToUserName   string `json:"ToUserName,omitempty"`
-	FromUserName string `json:"FromUserName,omitempty"`
-	InfoType     string `json:"InfoType,omitempty"`
-	MsgType      string `json:"MsgType,omitempty"`
-	Event        string `json:"Event,omitempty"`
-	Data         string `json:"Data,omitempty"`
-}
-
-func genWxCallBackReq(rule *model.WxCallbackRule) *wxCallBackReq {
-	if rule.InfoType != "" {
-		return &wxCallBackReq{
-			CreateTime: time.Now().Unix(),
-			InfoType:   rule.InfoType,
-			Data:       "TestData",
+	if appid == "" {
+		appid = "wxtestappid"
+		if appids := record.GetAppids(); len(appids) != 0 {
+			appid = appids[0]
 		}
-	} else {
-		return &wxCallBackReq{
-			CreateTime:   time.Now().Unix(),
-			MsgType:      rule.MsgType,
-			Event:        rule.Event,
-			ToUserName:   "TestUserName1",
-			FromUserName: "TestUserName2",
-			Data:         "TestData",
+	}
+	if len(payload) == 0 && req.Sample != "" {
+		sample := getCallbackSample(req.Sample)
+		if sample == nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("示例不存在"))
+			return
 		}
+		payload = genCallbackSample(sample, appid)
+	}
+	if len(payload) == 0 {
+		payload = genRuleDefaultSample(record, appid)
+	}
+	if !json.Valid(payload) {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("测试消息不是合法的json"))
+		return
+	}
+
+	res, err := wxcallback.TestCallbackRule(record, appid, string(payload))
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrRequestErr.WithData(err.Error()))
+		return
 	}
+	c.JSON(http.StatusOK, errno.OK.WithData(res))
 }
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	wxbase "github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/base"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// callbackSample 测试转发用的内置示例消息 fields为该类消息特有的字段
+type callbackSample struct {
+	Name     string                 `json:"name"`
+	Desc     string                 `json:"desc"`
+	InfoType string                 `json:"infoType,omitempty"`
+	MsgType  string                 `json:"msgType,omitempty"`
+	Event    string                 `json:"event,omitempty"`
+	Fields   map[string]interface{} `json:"-"`
+}
+
+var callbackSamples = []*callbackSample{
+	{Name: "authorized", Desc: "授权成功", InfoType: "authorized",
+		Fields: map[string]interface{}{"AuthorizationCode": "test_auth_code", "AuthorizationCodeExpiredTime": 3600,
+			"PreAuthCode": "test_pre_auth_code"}},
+	{Name: "updateauthorized", Desc: "更新授权", InfoType: "updateauthorized",
+		Fields: map[string]interface{}{"AuthorizationCode": "test_auth_code", "AuthorizationCodeExpiredTime": 3600,
+			"PreAuthCode": "test_pre_auth_code"}},
+	{Name: "unauthorized", Desc: "取消授权", InfoType: "unauthorized"},
+	{Name: "text", Desc: "文本消息", MsgType: "text",
+		Fields: map[string]interface{}{"Content": "测试消息"}},
+	{Name: "image", Desc: "图片消息", MsgType: "image",
+		Fields: map[string]interface{}{"PicUrl": "https://mmbiz.qpic.cn/test.jpg", "MediaId": "test_media_id"}},
+	{Name: "voice", Desc: "语音消息", MsgType: "voice",
+		Fields: map[string]interface{}{"MediaId": "test_media_id", "Format": "amr"}},
+	{Name: "video", Desc: "视频消息", MsgType: "video",
+		Fields: map[string]interface{}{"MediaId": "test_media_id", "ThumbMediaId": "test_thumb_media_id"}},
+	{Name: "location", Desc: "位置消息", MsgType: "location",
+		Fields: map[string]interface{}{"Location_X": 23.134521, "Location_Y": 113.358803, "Scale": 20,
+			"Label": "测试位置"}},
+	{Name: "link", Desc: "链接消息", MsgType: "link",
+		Fields: map[string]interface{}{"Title": "测试链接", "Description": "测试链接", "Url": "https://example.com"}},
+	{Name: "subscribe", Desc: "关注", MsgType: "event", Event: "subscribe"},
+	{Name: "unsubscribe", Desc: "取消关注", MsgType: "event", Event: "unsubscribe"},
+	{Name: "SCAN", Desc: "扫描带参数二维码", MsgType: "event", Event: "SCAN",
+		Fields: map[string]interface{}{"EventKey": "123", "Ticket": "test_ticket"}},
+	{Name: "CLICK", Desc: "点击菜单", MsgType: "event", Event: "CLICK",
+		Fields: map[string]interface{}{"EventKey": "test_key"}},
+	{Name: "VIEW", Desc: "点击菜单跳转链接", MsgType: "event", Event: "VIEW",
+		Fields: map[string]interface{}{"EventKey": "https://example.com"}},
+	{Name: model.WEAPPAUDIT_SUCCESS, Desc: "小程序审核通过", MsgType: "event", Event: model.WEAPPAUDIT_SUCCESS},
+	{Name: model.WEAPPAUDIT_FAIL, Desc: "小程序审核不通过", MsgType: "event", Event: model.WEAPPAUDIT_FAIL,
+		Fields: map[string]interface{}{"Reason": "测试原因"}},
+}
+
+// genCallbackSample 生成示例消息 appid为授权账号 授权事件中为AuthorizerAppid
+func genCallbackSample(sample *callbackSample, appid string) []byte {
+	msg := map[string]interface{}{"CreateTime": time.Now().Unix()}
+	if sample.InfoType != "" {
+		msg["AppId"] = wxbase.GetAppid()
+		msg["InfoType"] = sample.InfoType
+		msg["AuthorizerAppid"] = appid
+	} else {
+		// 有授权账号记录时使用真实的原始id
+		toUserName := "gh_test"
+		if authorizer, err := dao.GetAuthorizerRecordWithCache(appid); err == nil && authorizer != nil &&
+			authorizer.UserName != "" {
+			toUserName = authorizer.UserName
+		}
+		msg["ToUserName"] = toUserName
+		msg["FromUserName"] = "o_test_openid"
+		msg["MsgType"] = sample.MsgType
+		if sample.Event != "" {
+			msg["Event"] = sample.Event
+		} else {
+			msg["MsgId"] = time.Now().UnixNano()
+		}
+	}
+	for k, v := range sample.Fields {
+		msg[k] = v
+	}
+	value, _ := json.Marshal(msg)
+	return value
+}
+
+// getCallbackSample 按名称获取示例
+func getCallbackSample(name string) *callbackSample {
+	for _, v := range callbackSamples {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// genRuleDefaultSample 生成规则能匹配的示例消息 没有对应示例时按规则的消息类型生成
+func genRuleDefaultSample(rule *model.WxCallbackRule, appid string) []byte {
+	for _, v := range callbackSamples {
+		if (rule.InfoType != "" && v.InfoType != "" && matchSampleField(rule.InfoType, v.InfoType)) ||
+			(rule.InfoType == "" && v.InfoType == "" && matchSampleField(rule.MsgType, v.MsgType) &&
+				matchSampleField(rule.Event, v.Event)) {
+			return genCallbackSample(v, appid)
+		}
+	}
+	return genCallbackSample(&callbackSample{
+		InfoType: rule.InfoType,
+		MsgType:  rule.MsgType,
+		Event:    rule.Event,
+		Fields:   map[string]interface{}{"Data": "TestData"},
+	}, appid)
+}
+
+func matchSampleField(ruleValue string, value string) bool {
+	return ruleValue == model.CALLBACKRULE_WILDCARD || ruleValue == value
+}
+
+func getCallbackSamplesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, errno.OK.WithData(callbackSamples))
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+type getCallBackStatsReq struct {
+	StartTime   int64  `form:"startTime"`
+	EndTime     int64  `form:"endTime"`
+	Granularity string `form:"granularity"`
+	GroupBy     string `form:"groupBy"` // 逗号分隔 可选appid、event、rule
+	Appid       string `form:"appid"`
+	InfoType    string `form:"infoType"`
+	MsgType     string `form:"msgType"`
+	Event       string `form:"event"`
+	RuleId      int32  `form:"ruleId"`
+}
+
+// getCallBackStatsHandler 消息量和转发结果的时间序列 默认最近24小时按小时统计
+func getCallBackStatsHandler(c *gin.Context) {
+	var req getCallBackStatsReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.Granularity == "" {
+		req.Granularity = model.STATGRANULARITY_HOUR
+	}
+	if req.Granularity != model.STATGRANULARITY_HOUR && req.Granularity != model.STATGRANULARITY_DAY {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("时间粒度错误"))
+		return
+	}
+	var groupBy []string
+	if req.GroupBy != "" {
+		groupBy = strings.Split(req.GroupBy, ",")
+		for _, v := range groupBy {
+			if v != model.STATGROUP_APPID && v != model.STATGROUP_EVENT && v != model.STATGROUP_RULE {
+				c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("分组维度错误: "+v))
+				return
+			}
+		}
+	}
+	endTime := genEndTime(req.EndTime)
+	startTime := endTime.Add(-24 * time.Hour)
+	if req.StartTime != 0 {
+		startTime = time.Unix(req.StartTime, 0)
+	}
+	points, err := dao.GetCallBackStats(&dao.CallBackStatFilter{
+		StartTime: startTime,
+		EndTime:   endTime,
+		Appid:     req.Appid,
+		InfoType:  req.InfoType,
+		MsgType:   req.MsgType,
+		Event:     req.Event,
+		RuleID:    req.RuleId,
+	}, req.Granularity, groupBy)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"granularity": req.Granularity, "records": points}))
+}
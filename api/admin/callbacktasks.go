@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+type getCallBackDeliveryTasksReq struct {
+	Status int    `form:"status"`
+	RuleId int32  `form:"ruleId"`
+	Appid  string `form:"appid"`
+	Offset int    `form:"offset"`
+	Limit  int    `form:"limit"`
+}
+
+// getCallBackDeadLetterListHandler 异步转发任务列表 默认只看死信
+func getCallBackDeadLetterListHandler(c *gin.Context) {
+	var req getCallBackDeliveryTasksReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.Status == 0 {
+		req.Status = model.DELIVERYTASK_DEAD
+	}
+	records, total, err := dao.GetCallBackDeliveryTaskList(req.Status, req.RuleId, req.Appid,
+		req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "records": records}))
+}
+
+type redriveCallBackDeadLetterReq struct {
+	IDs []int64 `json:"ids"`
+}
+
+// redriveCallBackDeadLetterHandler 死信重新投递 ids为空时重新投递全部死信
+func redriveCallBackDeadLetterHandler(c *gin.Context) {
+	var req redriveCallBackDeadLetterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	count, err := dao.RedriveCallBackDeadTasks(req.IDs)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"count": count}))
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/wxcallback"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+type previewCallBackTransformReq struct {
+	RuleID    int32                `json:"ruleId"`    // 使用已保存规则的改写配置
+	Transform *model.RuleTransform `json:"transform"` // 未保存的改写配置 优先于ruleId
+	Type      int                  `json:"type"`      // 消息记录类型 与recordId一起使用
+	RecordID  int64                `json:"recordId"`
+	Appid     string               `json:"appid"`
+	Payload   json.RawMessage      `json:"payload"` // 未指定消息记录时使用
+}
+
+// previewCallBackTransformHandler 用改写配置渲染一条消息记录或自定义消息 不会转发
+func previewCallBackTransformHandler(c *gin.Context) {
+	var req previewCallBackTransformReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	transform := req.Transform
+	if transform != nil {
+		if err := transform.Compile(); err != nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+			return
+		}
+	} else if req.RuleID != 0 {
+		rule, err := dao.GetWxCallBackRuleById(req.RuleID)
+		if err != nil {
+			c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+			return
+		}
+		if transform, err = rule.GetTransform(); err != nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidStatus.WithData(err.Error()))
+			return
+		} else if transform == nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidStatus.WithData("该规则未配置改写"))
+			return
+		}
+	} else {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("请指定规则或改写配置"))
+		return
+	}
+
+	appid, body := req.Appid, string(req.Payload)
+	if req.RecordID != 0 {
+		switch req.Type {
+		case model.CALLBACKTYPE_COM:
+			record, err := dao.GetComponentCallBackRecordById(req.RecordID)
+			if err != nil {
+				c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+				return
+			}
+			appid, body = "", record.PostBody
+		case model.CALLBACKTYPE_BIZ:
+			record, err := dao.GetBizCallBackRecordById(req.RecordID)
+			if err != nil {
+				c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+				return
+			}
+			appid, body = record.Appid, record.PostBody
+		default:
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("消息类型错误"))
+			return
+		}
+	} else if body == "" {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("请指定消息记录或测试消息"))
+		return
+	}
+
+	result, err := wxcallback.RenderTransform(transform, req.RuleID, appid, body, nil)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("改写失败, "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+		"appid":  appid,
+		"source": body,
+		"result": result,
+	}))
+}
@@ -0,0 +1,452 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/proxy"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+)
+
+// 转发规则和代理配置的yaml导入导出 用于在多个环境之间同步配置
+// 规则按匹配条件(infoType、msgType、event、appids、conditions)对应已有规则 导入不会删除文档中没有的规则
+
+const configDocVersion = 1
+const maxConfigDocSize = 4 << 20
+
+// 导入时与已有配置冲突的处理方式
+const (
+	IMPORTSTRATEGY_SKIP      = "skip"
+	IMPORTSTRATEGY_OVERWRITE = "overwrite"
+	IMPORTSTRATEGY_FAIL      = "fail"
+)
+
+// 导入项的处理结果
+const (
+	IMPORTACTION_CREATE    = "create"
+	IMPORTACTION_UPDATE    = "update"
+	IMPORTACTION_SKIP      = "skip"
+	IMPORTACTION_UNCHANGED = "unchanged"
+	IMPORTACTION_CONFLICT  = "conflict"
+)
+
+type configDoc struct {
+	Version int               `yaml:"version"`
+	Proxy   *proxyDoc         `yaml:"proxy,omitempty"`
+	Rules   []callBackRuleDoc `yaml:"rules"`
+}
+
+type proxyDoc struct {
+	Open bool `yaml:"open"`
+	Port int  `yaml:"port"`
+}
+
+// callBackRuleDoc 文档中的规则 conditions、transform、data与管理接口的json格式相同
+type callBackRuleDoc struct {
+	Name          string      `yaml:"name"`
+	InfoType      string      `yaml:"infoType,omitempty"`
+	MsgType       string      `yaml:"msgType,omitempty"`
+	Event         string      `yaml:"event,omitempty"`
+	Appids        []string    `yaml:"appids,omitempty"`
+	Open          int         `yaml:"open"`
+	Type          int         `yaml:"type"`
+	Async         int         `yaml:"async,omitempty"`
+	Conditions    interface{} `yaml:"conditions,omitempty"`
+	Transform     interface{} `yaml:"transform,omitempty"`
+	ReplyDeadline int         `yaml:"replyDeadline,omitempty"`
+	Data          interface{} `yaml:"data"`
+}
+
+// ConfigImportItem 一项配置的导入结果
+type ConfigImportItem struct {
+	Kind   string            `json:"kind"` // rule或proxy
+	Name   string            `json:"name"`
+	RuleID int32             `json:"ruleId,omitempty"` // 对应的已有规则
+	Action string            `json:"action"`
+	Diff   []ConfigFieldDiff `json:"diff,omitempty"` // 与已有配置不同的字段
+}
+
+// ConfigFieldDiff 字段的差异
+type ConfigFieldDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ConfigImportResult 导入结果 dryRun时只计算差异不写入
+type ConfigImportResult struct {
+	DryRun    bool                `json:"dryRun"`
+	Strategy  string              `json:"strategy"`
+	Created   int                 `json:"created"`
+	Updated   int                 `json:"updated"`
+	Skipped   int                 `json:"skipped"`
+	Unchanged int                 `json:"unchanged"`
+	Conflicts int                 `json:"conflicts"` // 与已有配置内容不同的项数
+	Items     []*ConfigImportItem `json:"items"`
+}
+
+// ExportConfig 导出所有转发规则和代理配置为yaml
+func ExportConfig() ([]byte, error) {
+	rules, _, err := dao.GetWxCallBackRuleList(0, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	proxyConfig := proxy.GetProxyConfig()
+	doc := configDoc{
+		Version: configDocVersion,
+		Proxy:   &proxyDoc{Open: proxyConfig.Open, Port: proxyConfig.Port},
+		Rules:   make([]callBackRuleDoc, 0, len(rules)),
+	}
+	for _, v := range rules {
+		doc.Rules = append(doc.Rules, callBackRuleDoc{
+			Name:          v.Name,
+			InfoType:      v.InfoType,
+			MsgType:       v.MsgType,
+			Event:         v.Event,
+			Appids:        v.GetAppids(),
+			Open:          v.Open,
+			Type:          v.Type,
+			Async:         v.Async,
+			Conditions:    decodeJsonValue(v.Conditions),
+			Transform:     decodeJsonValue(v.Transform),
+			ReplyDeadline: v.ReplyDeadline,
+			Data:          decodeJsonValue(v.Info),
+		})
+	}
+	return yaml.Marshal(&doc)
+}
+
+type importRule struct {
+	rule     *model.WxCallbackRule
+	existing *model.WxCallbackRule
+	item     *ConfigImportItem
+}
+
+// ImportConfig 导入yaml中的转发规则和代理配置 operator记录在规则的变更历史中
+// 先检查全部配置再在一个事务中写入 fail策略下有冲突或写入失败时不写入任何配置
+func ImportConfig(data []byte, strategy string, dryRun bool, operator string) (*ConfigImportResult, error) {
+	if strategy == "" {
+		strategy = IMPORTSTRATEGY_FAIL
+	}
+	if strategy != IMPORTSTRATEGY_SKIP && strategy != IMPORTSTRATEGY_OVERWRITE && strategy != IMPORTSTRATEGY_FAIL {
+		return nil, fmt.Errorf("不支持的冲突处理方式: %s", strategy)
+	}
+	var doc configDoc
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("yaml格式有误: %v", err)
+	}
+	if doc.Version != configDocVersion {
+		return nil, fmt.Errorf("不支持的文档版本: %d", doc.Version)
+	}
+	existingRules, _, err := dao.GetWxCallBackRuleList(0, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	existingMap := make(map[string]*model.WxCallbackRule, len(existingRules))
+	for _, v := range existingRules {
+		existingMap[genRuleMatchKey(v)] = v
+	}
+
+	res := &ConfigImportResult{DryRun: dryRun, Strategy: strategy, Items: []*ConfigImportItem{}}
+	imports := make([]*importRule, 0, len(doc.Rules))
+	docKeys := make(map[string]int, len(doc.Rules))
+	for i := range doc.Rules {
+		rule, err := genImportRule(&doc.Rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("第%d条规则(%s)有误: %v", i+1, doc.Rules[i].Name, err)
+		}
+		key := genRuleMatchKey(rule)
+		if j, ok := docKeys[key]; ok {
+			return nil, fmt.Errorf("第%d条规则与第%d条规则的匹配条件相同", i+1, j+1)
+		}
+		docKeys[key] = i
+		item := &ConfigImportItem{Kind: "rule", Name: rule.Name}
+		existing := existingMap[key]
+		if rule.Info, err = model.RestoreMaskedRuleInfo(rule.Type, rule.Info, existing); err != nil {
+			return nil, fmt.Errorf("第%d条规则(%s)有误: %v", i+1, doc.Rules[i].Name, err)
+		}
+		if existing == nil {
+			item.Action = IMPORTACTION_CREATE
+		} else {
+			item.RuleID = existing.ID
+			item.Diff = diffCallBackRule(existing, rule)
+			item.Action = resolveImportAction(item.Diff, strategy)
+		}
+		imports = append(imports, &importRule{rule: rule, existing: existing, item: item})
+		res.Items = append(res.Items, item)
+	}
+	var proxyItem *ConfigImportItem
+	if doc.Proxy != nil {
+		current := proxy.GetProxyConfig()
+		proxyItem = &ConfigImportItem{Kind: "proxy", Name: "proxy"}
+		if current.Open != doc.Proxy.Open {
+			proxyItem.Diff = append(proxyItem.Diff, ConfigFieldDiff{Field: "open", Old: current.Open, New: doc.Proxy.Open})
+		}
+		if current.Port != doc.Proxy.Port {
+			proxyItem.Diff = append(proxyItem.Diff, ConfigFieldDiff{Field: "port", Old: current.Port, New: doc.Proxy.Port})
+		}
+		proxyItem.Action = resolveImportAction(proxyItem.Diff, strategy)
+		res.Items = append(res.Items, proxyItem)
+	}
+	for _, v := range res.Items {
+		countImportAction(res, v)
+	}
+	if dryRun {
+		return res, nil
+	}
+	if res.Conflicts != 0 && strategy == IMPORTSTRATEGY_FAIL {
+		return res, fmt.Errorf("有%d项配置与已有配置冲突", res.Conflicts)
+	}
+
+	// 规则和代理配置在同一个事务中写入 任一项失败时全部回滚
+	var applyProxy func()
+	err = db.Get().Transaction(func(tx *gorm.DB) error {
+		for _, v := range imports {
+			var err error
+			switch v.item.Action {
+			case IMPORTACTION_CREATE:
+				err = dao.AddWxCallBackRuleWithTx(tx, v.rule, operator)
+				v.item.RuleID = v.rule.ID
+			case IMPORTACTION_UPDATE:
+				v.rule.ID = v.existing.ID
+				err = dao.UpdateWxCallBackRuleWithTx(tx, v.rule, operator)
+			}
+			if err != nil {
+				return fmt.Errorf("导入规则%s失败: %v", v.rule.Name, err)
+			}
+		}
+		if proxyItem != nil && proxyItem.Action == IMPORTACTION_UPDATE {
+			var err error
+			if applyProxy, err = proxy.SetProxyConfigWithTx(tx, doc.Proxy.Open, doc.Proxy.Port, ""); err != nil {
+				return fmt.Errorf("导入代理配置失败: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		for _, v := range imports {
+			if v.item.Action == IMPORTACTION_CREATE {
+				v.item.RuleID = 0
+			}
+		}
+		return res, err
+	}
+	dao.ClearWxCallBackRuleCache()
+	if applyProxy != nil {
+		applyProxy()
+	}
+	return res, nil
+}
+
+// resolveImportAction 按冲突处理方式决定已有配置的处理结果
+func resolveImportAction(diff []ConfigFieldDiff, strategy string) string {
+	if len(diff) == 0 {
+		return IMPORTACTION_UNCHANGED
+	}
+	switch strategy {
+	case IMPORTSTRATEGY_SKIP:
+		return IMPORTACTION_SKIP
+	case IMPORTSTRATEGY_OVERWRITE:
+		return IMPORTACTION_UPDATE
+	}
+	return IMPORTACTION_CONFLICT
+}
+
+func countImportAction(res *ConfigImportResult, item *ConfigImportItem) {
+	if len(item.Diff) != 0 {
+		res.Conflicts++
+	}
+	switch item.Action {
+	case IMPORTACTION_CREATE:
+		res.Created++
+	case IMPORTACTION_UPDATE:
+		res.Updated++
+	case IMPORTACTION_SKIP:
+		res.Skipped++
+	case IMPORTACTION_UNCHANGED:
+		res.Unchanged++
+	}
+}
+
+// genImportRule 把文档中的规则转为管理接口的格式后按相同的规则检查
+func genImportRule(doc *callBackRuleDoc) (*model.WxCallbackRule, error) {
+	req := callBackProxyRule{
+		Name:          doc.Name,
+		InfoType:      doc.InfoType,
+		MsgType:       doc.MsgType,
+		Event:         doc.Event,
+		Appids:        doc.Appids,
+		Open:          doc.Open,
+		Type:          doc.Type,
+		Async:         doc.Async,
+		ReplyDeadline: doc.ReplyDeadline,
+	}
+	var err error
+	if req.Data, err = encodeYamlValue(doc.Data); err != nil {
+		return nil, err
+	}
+	if doc.Conditions != nil {
+		value, err := encodeYamlValue(doc.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(value, &req.Conditions); err != nil {
+			return nil, fmt.Errorf("conditions格式有误: %v", err)
+		}
+	}
+	if doc.Transform != nil {
+		value, err := encodeYamlValue(doc.Transform)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(value, &req.Transform); err != nil {
+			return nil, fmt.Errorf("transform格式有误: %v", err)
+		}
+	}
+	return genCallBackRule(&req)
+}
+
+// genRuleMatchKey 规则的匹配条件 相同时视为同一条规则
+func genRuleMatchKey(rule *model.WxCallbackRule) string {
+	return strings.Join([]string{rule.InfoType, rule.MsgType, rule.Event, rule.Appids, rule.Conditions}, "\n")
+}
+
+// diffCallBackRule 比较匹配条件相同的两条规则 json字段按内容比较
+func diffCallBackRule(existing *model.WxCallbackRule, imported *model.WxCallbackRule) []ConfigFieldDiff {
+	var diff []ConfigFieldDiff
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"name", existing.Name, imported.Name},
+		{"open", existing.Open, imported.Open},
+		{"type", existing.Type, imported.Type},
+		{"async", existing.Async, imported.Async},
+		{"transform", decodeJsonValue(existing.Transform), decodeJsonValue(imported.Transform)},
+		{"replyDeadline", existing.ReplyDeadline, imported.ReplyDeadline},
+		{"data", decodeJsonValue(existing.Info), decodeJsonValue(imported.Info)},
+	}
+	for _, v := range fields {
+		if !reflect.DeepEqual(v.old, v.new) {
+			if v.name == "data" {
+				// 密钥有变化时也只展示掩码
+				v.old = decodeJsonValue(model.MaskRuleInfo(existing.Type, existing.Info))
+				v.new = decodeJsonValue(model.MaskRuleInfo(imported.Type, imported.Info))
+			}
+			diff = append(diff, ConfigFieldDiff{Field: v.name, Old: v.old, New: v.new})
+		}
+	}
+	return diff
+}
+
+// decodeJsonValue 解析规则中的json字段 为空时返回nil
+func decodeJsonValue(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	var result interface{}
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return value
+	}
+	return result
+}
+
+// encodeYamlValue yaml解析出的值转为json yaml中的map的key可能不是字符串
+func encodeYamlValue(value interface{}) (json.RawMessage, error) {
+	converted, err := convertYamlValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+func convertYamlValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted, err := convertYamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			switch k := key.(type) {
+			case string:
+				result[k] = converted
+			case int:
+				result[strconv.Itoa(k)] = converted
+			default:
+				return nil, fmt.Errorf("不支持的key: %v", key)
+			}
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := convertYamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+func exportConfigHandler(c *gin.Context) {
+	value, err := ExportConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=wxcomponent-config-%s.yaml",
+		time.Now().Format("20060102150405")))
+	c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", value)
+}
+
+type importConfigReq struct {
+	Strategy string `form:"strategy"`
+	DryRun   bool   `form:"dryRun"`
+}
+
+// importConfigHandler 请求体为yaml文档
+func importConfigHandler(c *gin.Context) {
+	var req importConfigReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxConfigDocSize))
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("yaml文档为空"))
+		return
+	}
+	res, err := ImportConfig(data, req.Strategy, req.DryRun, getOperator(c))
+	if err != nil {
+		if res != nil {
+			c.JSON(http.StatusOK, errno.ErrInvalidStatus.WithData(gin.H{"error": err.Error(), "result": res}))
+			return
+		}
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(res))
+}
@@ -67,10 +67,14 @@ type getLatestAuditStatusResp struct {
 }
 
 type devVersionsResp struct {
-	AuditVersion *getLatestAuditStatusResp `json:"auditInfo,omitempty"`
+	AuditVersion   *getLatestAuditStatusResp `json:"auditInfo,omitempty"`
+	ReleaseState   *model.WxReleaseState     `json:"releaseState,omitempty"`
+	ReleaseHistory []*model.WxReleaseHistory `json:"releaseHistory"`
 	getVersionInfoResp
 }
 
+const releaseHistoryLimit = 20
+
 type templateListResp struct {
 	TemplateList []templateItem `json:"templateList" wx:"template_list"`
 }
@@ -341,6 +345,18 @@ func submitAuditHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
+	// autoRelease不传时沿用之前的设置
+	autoRelease, hasAutoRelease := c.GetQuery("autoRelease")
+	if _, err := dao.TransitReleaseState(appid, model.RELEASESTATE_AUDITING, model.RELEASESOURCE_MANUAL, "",
+		func(state *model.WxReleaseState) {
+			state.AuditId = int64(auditId)
+			if hasAutoRelease {
+				state.AutoRelease, _ = strconv.Atoi(autoRelease)
+			}
+		}); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData("已提交审核 更新发布状态失败: "+err.Error()))
+		return
+	}
 	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"auditId": auditId}))
 }
 
@@ -400,6 +416,13 @@ func devVersionsHandler(c *gin.Context) {
 			}
 		}()
 	}
+	// 发布状态和变更历史
+	if state, err := dao.GetReleaseState(appid); err == nil && state.State != model.RELEASESTATE_NONE {
+		resp.ReleaseState = state
+	}
+	if history, _, err := dao.GetReleaseHistory(appid, 0, releaseHistoryLimit); err == nil {
+		resp.ReleaseHistory = history
+	}
 	wg.Wait()
 	c.JSON(http.StatusOK, errno.OK.WithData(resp))
 }
@@ -427,6 +450,11 @@ func revokeAuditHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
+	if _, err := dao.TransitReleaseState(appid, model.RELEASESTATE_COMMITTED, model.RELEASESOURCE_MANUAL,
+		"撤回审核", nil); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData("已撤回审核 更新发布状态失败: "+err.Error()))
+		return
+	}
 	c.JSON(http.StatusOK, errno.OK)
 }
 
@@ -458,6 +486,14 @@ func commitCodeHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
+	if _, err := dao.TransitReleaseState(appid, model.RELEASESTATE_COMMITTED, model.RELEASESOURCE_MANUAL, "",
+		func(state *model.WxReleaseState) {
+			state.UserVersion = req.UserVersion
+			state.AuditId = 0
+		}); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData("已提交代码 更新发布状态失败: "+err.Error()))
+		return
+	}
 	c.JSON(http.StatusOK, errno.OK)
 }
 
@@ -468,6 +504,30 @@ func releaseCodeHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
+	if _, err := dao.TransitReleaseState(appid, model.RELEASESTATE_RELEASED, model.RELEASESOURCE_MANUAL,
+		"", nil); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData("已发布 更新发布状态失败: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+type setAutoReleaseReq struct {
+	AutoRelease int `json:"autoRelease"`
+}
+
+// setAutoReleaseHandler 设置审核通过后是否自动发布
+func setAutoReleaseHandler(c *gin.Context) {
+	appid := c.DefaultQuery("appid", "")
+	var req setAutoReleaseReq
+	if err := c.ShouldBindJSON(&req); err != nil || appid == "" {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam)
+		return
+	}
+	if err := dao.SetReleaseAutoRelease(appid, req.AutoRelease); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
 	c.JSON(http.StatusOK, errno.OK)
 }
 
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/gin-gonic/gin"
+)
+
+// getCallBackRetentionHandler 消息记录的保留策略和最近一次清理结果
+func getCallBackRetentionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+		"purgeInterval":   config.RetentionConf.PurgeInterval,
+		"purgeBatchSize":  config.RetentionConf.PurgeBatchSize,
+		"purgeBatchPause": config.RetentionConf.PurgeBatchPause,
+		"policies":        dao.GetRetentionPolicies(),
+		"lastPurge":       dao.GetLastRetentionPurge(),
+	}))
+}
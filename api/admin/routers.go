@@ -2,6 +2,7 @@ package admin
 
 import (
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/innerservice"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/middleware"
 	"github.com/gin-gonic/gin"
 )
@@ -20,23 +21,51 @@ func Routers(e *gin.RouterGroup) {
 
 	// 第三方token
 	g.GET("/cloudbase-access-token", getCloudbaseAccessTokenHandler)
-	g.GET("/component-access-token", innerservice.GetComponentAccessTokenHandler)
-	g.GET("/authorizer-access-token", innerservice.GetAuthorizerAccessTokenHandler)
-	g.GET("/ticket", innerservice.GetTicketHandler)
+	g.GET("/component-access-token", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_COMPONENT),
+		innerservice.GetComponentAccessTokenHandler)
+	g.GET("/authorizer-access-token", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_AUTHORIZER),
+		innerservice.GetAuthorizerAccessTokenHandler)
+	g.GET("/ticket", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_TICKET), innerservice.GetTicketHandler)
+	g.GET("/token-refresh-status", getTokenRefreshStatusHandler)
+
+	// 内部服务客户端
+	g.GET("/service-client-list", getServiceClientListHandler)
+	g.PUT("/service-client", addServiceClientHandler)
+	g.POST("/service-client", updateServiceClientHandler)
+	g.DELETE("/service-client", delServiceClientHandler)
+	g.POST("/service-client-secret", resetServiceClientSecretHandler)
 
 	// 消息与事件
 	g.GET("/wx-component-records", getWxComponentRecordsHandler)
 	g.GET("/wx-biz-records", getWxBizRecordsHandler)
+	g.GET("/wx-component-records-export", exportWxComponentRecordsHandler)
+	g.GET("/wx-biz-records-export", exportWxBizRecordsHandler)
+	g.GET("/callback-delivery-records", getCallBackDeliveryRecordsHandler)
+	g.GET("/callback-dead-letter-list", getCallBackDeadLetterListHandler)
+	g.POST("/callback-dead-letter-redrive", redriveCallBackDeadLetterHandler)
+	g.GET("/callback-retention", getCallBackRetentionHandler)
+	g.GET("/callback-stats", getCallBackStatsHandler)
+	g.POST("/callback-replay", replayCallBackRecordsHandler)
+	g.GET("/callback-replay-job", getCallBackReplayJobHandler)
 	g.GET("/callback-config", getWxCallBackConfigHandler)
 	g.GET("/callback-proxy-rule-list", getCallBackProxyRuleListHandler)
 	g.POST("/callback-proxy-rule", updateCallBackProxyRuleHandler)
 	g.PUT("/callback-proxy-rule", addCallBackProxyRuleHandler)
 	g.DELETE("/callback-proxy-rule", delCallBackProxyRuleHandler)
+	g.GET("/callback-proxy-rule-history", getCallBackRuleHistoryHandler)
+	g.POST("/callback-proxy-rule-restore", restoreCallBackRuleHandler)
 	g.POST("/callback-test", testCallbackRuleHandler)
+	g.GET("/callback-test-samples", getCallbackSamplesHandler)
+	g.POST("/callback-transform-preview", previewCallBackTransformHandler)
+	g.GET("/auto-reply-list", getAutoReplyRuleListHandler)
+	g.PUT("/auto-reply", addAutoReplyRuleHandler)
+	g.POST("/auto-reply", updateAutoReplyRuleHandler)
+	g.DELETE("/auto-reply", delAutoReplyRuleHandler)
 
 	// 授权小程序管理
 	g.POST("/pull-authorizer-list", pullAuthorizerListHandler)
 	g.GET("/authorizer-list", getAuthorizerListHandler)
+	g.GET("/third-notify-list", getThirdNotifyListHandler)
 
 	// 代开发小程序管理
 	g.GET("/dev-weapp-list", getDevWeAppListHandler)
@@ -47,6 +76,7 @@ func Routers(e *gin.RouterGroup) {
 	g.POST("/speed-up-audit", speedUpAuditHandler)
 	g.POST("/commit-code", commitCodeHandler)
 	g.POST("/release-code", releaseCodeHandler)
+	g.POST("/auto-release", setAutoReleaseHandler)
 	g.POST("/upload-media", uploadMediaHandler)
 	g.POST("/change-visit-status", changeVisitStatusHandler)
 	g.POST("/rollback-release-version", rollbackReleaseVersionHandler)
@@ -69,4 +99,8 @@ func Routers(e *gin.RouterGroup) {
 	// 转发设置
 	g.GET("/proxy", getProxyHandler)
 	g.POST("/proxy", updateProxyHandler)
+
+	// 转发规则和代理配置的导入导出
+	g.GET("/config-export", exportConfigHandler)
+	g.POST("/config-import", importConfigHandler)
 }
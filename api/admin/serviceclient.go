@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// serviceClientNameRegex 客户端名称 不能包含Bearer凭证中的分隔符
+var serviceClientNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+var serviceTokenTypes = []string{model.SERVICETOKEN_COMPONENT, model.SERVICETOKEN_AUTHORIZER,
+	model.SERVICETOKEN_TICKET}
+
+type getServiceClientListReq struct {
+	Offset int `form:"offset"`
+	Limit  int `form:"limit"`
+}
+
+type serviceClient struct {
+	ID         int32    `json:"id"`
+	Name       string   `json:"name"`
+	Appids     []string `json:"appids"`
+	TokenTypes []string `json:"tokenTypes"`
+	Open       int      `json:"open"`
+	ExpireTime int64    `json:"expireTime"` // 秒级时间戳 为0时不过期
+	CreateTime int64    `json:"createTime"`
+	UpdateTime int64    `json:"updateTime"`
+}
+
+func getServiceClientListHandler(c *gin.Context) {
+	var req getServiceClientListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	records, total, err := dao.GetServiceClientList(req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	res := make([]serviceClient, 0, len(records))
+	for _, v := range records {
+		client := serviceClient{
+			ID:         v.ID,
+			Name:       v.Name,
+			Appids:     splitServiceClientList(v.Appids),
+			TokenTypes: splitServiceClientList(v.TokenTypes),
+			Open:       v.Open,
+			CreateTime: v.CreateTime.Unix(),
+			UpdateTime: v.UpdateTime.Unix(),
+		}
+		if v.ExpireTime != nil {
+			client.ExpireTime = v.ExpireTime.Unix()
+		}
+		res = append(res, client)
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "records": res}))
+}
+
+// addServiceClientHandler 添加客户端 返回的密钥只展示一次 未指定open时默认启用
+func addServiceClientHandler(c *gin.Context) {
+	req := serviceClient{Open: 1}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if !serviceClientNameRegex.MatchString(req.Name) {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("名称只能包含字母、数字和_.- 不超过64个字符"))
+		return
+	}
+	record, err := genServiceClient(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	record.Name = req.Name
+	if record.Secret, err = genServiceClientSecret(); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	if err = dao.AddServiceClient(record); err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"id": record.ID, "secret": record.Secret}))
+}
+
+// updateServiceClientHandler 更新客户端的权限 名称不可修改
+func updateServiceClientHandler(c *gin.Context) {
+	var req serviceClient
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.ID == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端id为空"))
+		return
+	}
+	record, err := genServiceClient(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if err = dao.UpdateServiceClient(record); err != nil {
+		if errors.Is(err, dao.ErrServiceClientNotFound) {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端不存在"))
+			return
+		}
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+type serviceClientId struct {
+	ID int32 `form:"id" json:"id"`
+}
+
+func delServiceClientHandler(c *gin.Context) {
+	var req serviceClientId
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.ID == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端id为空"))
+		return
+	}
+	if err := dao.DelServiceClient(req.ID); err != nil {
+		if errors.Is(err, dao.ErrServiceClientNotFound) {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端不存在"))
+			return
+		}
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK)
+}
+
+// resetServiceClientSecretHandler 重置密钥 原密钥立即失效
+func resetServiceClientSecretHandler(c *gin.Context) {
+	var req serviceClientId
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	if req.ID == 0 {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端id为空"))
+		return
+	}
+	secret, err := genServiceClientSecret()
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	if err = dao.UpdateServiceClientSecret(req.ID, secret); err != nil {
+		if errors.Is(err, dao.ErrServiceClientNotFound) {
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData("客户端不存在"))
+			return
+		}
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"secret": secret}))
+}
+
+// genServiceClient 检查客户端的权限配置 不包含名称和密钥
+func genServiceClient(req *serviceClient) (*model.ServiceClient, error) {
+	tokenTypes, err := checkServiceClientList(req.TokenTypes)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenTypes) == 0 {
+		return nil, errors.New("凭证类型为空")
+	}
+	for _, v := range tokenTypes {
+		if !containsString(serviceTokenTypes, v) {
+			return nil, fmt.Errorf("凭证类型有误: %s", v)
+		}
+	}
+	if req.Open != 0 && req.Open != 1 {
+		return nil, errors.New("open只能为0或1")
+	}
+	appids, err := checkServiceClientList(req.Appids)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(tokenTypes, model.SERVICETOKEN_AUTHORIZER) && len(appids) == 0 {
+		return nil, errors.New("获取授权账号token时appid列表不能为空 所有授权账号为*")
+	}
+	record := &model.ServiceClient{
+		ID:         req.ID,
+		Appids:     strings.Join(appids, ","),
+		TokenTypes: strings.Join(tokenTypes, ","),
+		Open:       req.Open,
+	}
+	if len(record.Appids) > 2048 {
+		return nil, errors.New("appid列表过长")
+	}
+	if req.ExpireTime != 0 {
+		expireTime := time.Unix(req.ExpireTime, 0)
+		record.ExpireTime = &expireTime
+	}
+	return record, nil
+}
+
+// checkServiceClientList 去重排序 不能包含空值和逗号
+func checkServiceClientList(list []string) ([]string, error) {
+	values := make(map[string]bool, len(list))
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Contains(v, ",") {
+			return nil, fmt.Errorf("格式有误: %q", v)
+		}
+		values[v] = true
+	}
+	res := make([]string, 0, len(values))
+	for v := range values {
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func splitServiceClientList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func genServiceClientSecret() (string, error) {
+	value := make([]byte, 24)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/gin-gonic/gin"
+)
+
+type getThirdNotifyListReq struct {
+	InfoType string `form:"infoType"`
+	Appid    string `form:"appid"`
+	Offset   int    `form:"offset"`
+	Limit    int    `form:"limit"`
+}
+
+// getThirdNotifyListHandler 快速注册、快速认证、名称审核等通知的处理结果
+func getThirdNotifyListHandler(c *gin.Context) {
+	var req getThirdNotifyListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	records, total, err := dao.GetThirdNotifyList(req.InfoType, req.Appid, req.Offset, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"total": total, "records": records}))
+}
@@ -3,7 +3,9 @@ package admin
 import (
 	"net/http"
 
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/cloudbasetoken"
 	"github.com/gin-gonic/gin"
@@ -12,3 +14,13 @@ import (
 func getCloudbaseAccessTokenHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{"token": cloudbasetoken.GetCloudBaseAccessToken()}))
 }
+
+// getTokenRefreshStatusHandler token主动刷新的计划和最近的错误 状态只包含当前实例的刷新结果
+func getTokenRefreshStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, errno.OK.WithData(gin.H{
+		"interval": config.TokenRefreshConf.Interval,
+		"advance":  config.TokenRefreshConf.Advance,
+		"instance": wx.GetTokenRefreshInstance(),
+		"tokens":   wx.GetTokenRefreshStatus(),
+	}))
+}
@@ -1,14 +1,17 @@
 package innerservice
 
 import (
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 // Routers 路由
 func Routers(e *gin.Engine) {
-	g := e.Group("/inner", middleware.InnerServiceMiddleWare)
-	g.GET("/component-access-token", GetComponentAccessTokenHandler)
-	g.GET("/authorizer-access-token", GetAuthorizerAccessTokenHandler)
-	g.GET("/ticket", GetTicketHandler)
+	g := e.Group("/inner", middleware.InnerServiceClientMiddleWare)
+	g.GET("/component-access-token", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_COMPONENT),
+		GetComponentAccessTokenHandler)
+	g.GET("/authorizer-access-token", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_AUTHORIZER),
+		GetAuthorizerAccessTokenHandler)
+	g.GET("/ticket", middleware.ServiceTokenMiddleWare(model.SERVICETOKEN_TICKET), GetTicketHandler)
 }
@@ -9,9 +9,11 @@ import (
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // ProxyConfig 代理配置
@@ -48,26 +50,37 @@ func GetProxyConfig() ProxyConfig {
 
 // SetProxyConfig 设置代理配置
 func SetProxyConfig(open bool, port int, path string) error {
-	proxyConfig = ProxyConfig{
+	apply, err := SetProxyConfigWithTx(db.Get(), open, port, path)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// SetProxyConfigWithTx 在事务中保存代理配置 事务提交后调用返回的apply使配置生效
+func SetProxyConfigWithTx(tx *gorm.DB, open bool, port int, path string) (func(), error) {
+	newConfig := ProxyConfig{
 		Open: open,
 		Port: port,
 		Path: path,
 		Url:  fmt.Sprintf("http://127.0.0.1:%d%s", port, path),
 	}
+	var newTarget *url.URL
 	if open {
 		var err error
-		if target, err = url.Parse(proxyConfig.Url); err != nil {
+		if newTarget, err = url.Parse(newConfig.Url); err != nil {
 			log.Errorf("url Parse error: %v", err)
-			return err
+			return nil, err
 		}
-	} else {
-		target = nil
 	}
-	if err := setProxyConfigToKv(&proxyConfig); err != nil {
+	if err := setProxyConfigToKv(tx, &newConfig); err != nil {
 		log.Errorf("url setProxyConfigToKv error: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	return func() {
+		proxyConfig, target = newConfig, newTarget
+	}, nil
 }
 
 func getProxyConfigFromKv(proxyConfig *ProxyConfig) {
@@ -82,10 +95,10 @@ func getProxyConfigFromKv(proxyConfig *ProxyConfig) {
 	log.Infof("getProxyConfigFromKv %v", *proxyConfig)
 }
 
-func setProxyConfigToKv(proxyConfig *ProxyConfig) error {
+func setProxyConfigToKv(tx *gorm.DB, proxyConfig *ProxyConfig) error {
 	value, _ := json.Marshal(*proxyConfig)
 	log.Infof("setProxyConfigToKv %v", *proxyConfig)
-	return dao.SetCommKv("proxy", string(value))
+	return dao.SetCommKvWithTx(tx, "proxy", string(value))
 }
 
 // Init 初始化
@@ -0,0 +1,163 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 异步转发：消息先写入投递队列，立即回复微信success，由后台协程投递并按指数退避重试
+// 超过最大重试次数后转为死信，可在管理端重新投递
+
+const asyncTaskLease = 2 * time.Minute
+const asyncPollInterval = time.Second
+const asyncMaxBackoff = time.Hour
+
+var asyncNotify = make(chan struct{}, 1)
+
+// AsyncEnabled 是否启动了异步投递协程 未启动时异步规则按同步转发
+func AsyncEnabled() bool {
+	return config.WxCallbackConf.AsyncWorkers > 0
+}
+
+// Init 启动异步转发的投递协程
+func Init() error {
+	if !AsyncEnabled() {
+		log.Info("async callback delivery disabled, async rules are delivered synchronously")
+		return nil
+	}
+	tasks := make(chan *model.WxCallbackDeliveryTask)
+	for i := 0; i < config.WxCallbackConf.AsyncWorkers; i++ {
+		go asyncDeliveryWorker(tasks)
+	}
+	go asyncDeliveryDispatcher(tasks)
+	return nil
+}
+
+func notifyAsyncDelivery() {
+	select {
+	case asyncNotify <- struct{}{}:
+	default:
+	}
+}
+
+func asyncDeliveryDispatcher(tasks chan<- *model.WxCallbackDeliveryTask) {
+	ticker := time.NewTicker(asyncPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-asyncNotify:
+		}
+		records, err := dao.GetDueCallBackDeliveryTasks(config.WxCallbackConf.AsyncWorkers * 10)
+		if err != nil {
+			log.Errorf("GetDueCallBackDeliveryTasks err %v", err)
+			continue
+		}
+		for _, task := range records {
+			tasks <- task
+		}
+	}
+}
+
+func asyncDeliveryWorker(tasks <-chan *model.WxCallbackDeliveryTask) {
+	for task := range tasks {
+		// 多实例部署时通过抢占避免重复投递
+		if ok, err := dao.ClaimCallBackDeliveryTask(task, asyncTaskLease); err != nil || !ok {
+			continue
+		}
+		if err := deliverTask(task); err != nil {
+			retryTask(task, err)
+			continue
+		}
+		dao.DelCallBackDeliveryTask(task.ID)
+	}
+}
+
+func retryTask(task *model.WxCallbackDeliveryTask, deliverErr error) {
+	status := model.DELIVERYTASK_PENDING
+	if task.Attempts >= config.WxCallbackConf.AsyncMaxAttempts {
+		status = model.DELIVERYTASK_DEAD
+		log.Errorf("delivery task %d dead after %d attempts: %v", task.ID, task.Attempts, deliverErr)
+	}
+	backoff := time.Duration(config.WxCallbackConf.AsyncRetryBackoff) * time.Second
+	for i := 1; i < task.Attempts && backoff < asyncMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > asyncMaxBackoff {
+		backoff = asyncMaxBackoff
+	}
+	dao.UpdateCallBackDeliveryTaskRetry(task.ID, status, time.Now().Add(backoff), deliverErr.Error())
+}
+
+func deliverTask(task *model.WxCallbackDeliveryTask) error {
+	rule, err := dao.GetWxCallBackRuleById(task.RuleID)
+	if err != nil {
+		return err
+	}
+	var header http.Header
+	if task.Header != "" {
+		json.Unmarshal([]byte(task.Header), &header)
+	}
+	sinks, err := NewSinks(rule, task.Appid)
+	if err != nil {
+		return err
+	}
+	if task.TargetIndex >= len(sinks) {
+		return errors.New("转发目标不存在")
+	}
+	// 重放的消息进入队列后仍带上重放标记
+	replayId, _ := strconv.ParseInt(header.Get(ReplayHeader), 10, 64)
+	record := deliverToSink(rule, sinks[task.TargetIndex], task.TargetIndex == 0, &SinkMsg{
+		Appid:    task.Appid,
+		Header:   header,
+		RawQuery: task.RawQuery,
+		Body:     task.PostBody,
+		ReplayID: replayId,
+	})
+	if record.Result != model.DELIVERYRESULT_SUCC {
+		return errors.New(record.ErrMsg)
+	}
+	return nil
+}
+
+// enqueueCallbackMsg 每个转发目标生成一个异步任务
+func enqueueCallbackMsg(rule *model.WxCallbackRule, appid string, header http.Header,
+	rawQuery string, body string) error {
+	sinks, err := NewSinks(rule, appid)
+	if err != nil {
+		return err
+	}
+	targetCount := len(sinks)
+	headerJson, _ := json.Marshal(header)
+	now := time.Now()
+	tasks := make([]*model.WxCallbackDeliveryTask, 0, targetCount)
+	for i := 0; i < targetCount; i++ {
+		tasks = append(tasks, &model.WxCallbackDeliveryTask{
+			RuleID:        rule.ID,
+			TargetIndex:   i,
+			Appid:         appid,
+			InfoType:      rule.InfoType,
+			MsgType:       rule.MsgType,
+			Event:         rule.Event,
+			Header:        string(headerJson),
+			RawQuery:      rawQuery,
+			PostBody:      body,
+			Status:        model.DELIVERYTASK_PENDING,
+			NextRetryTime: now,
+		})
+	}
+	if err := dao.AddCallBackDeliveryTasks(tasks); err != nil {
+		return err
+	}
+	log.Infof("enqueue: %v, tasks %d", rule, len(tasks))
+	notifyAsyncDelivery()
+	return nil
+}
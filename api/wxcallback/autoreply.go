@@ -0,0 +1,117 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 内置自动回复：没有转发规则处理的公众号消息 按关键词或关注事件被动回复
+
+type wxAutoReplyMsg struct {
+	ToUserName   string `json:"ToUserName"`
+	FromUserName string `json:"FromUserName"`
+	MsgType      string `json:"MsgType"`
+	Event        string `json:"Event"`
+	Content      string `json:"Content"`
+}
+
+// autoReply 命中自动回复规则时写入被动回复 返回是否已回复
+func autoReply(appid string, body []byte, c *gin.Context) bool {
+	var msg wxAutoReplyMsg
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return false
+	}
+	// 小程序不支持被动回复
+	if authorizer, err := dao.GetAuthorizerRecordWithCache(appid); err != nil || authorizer == nil ||
+		authorizer.AppType != model.APPTYPE_MP {
+		return false
+	}
+	rule, err := matchAutoReplyRule(appid, &msg)
+	if err != nil || rule == nil {
+		return false
+	}
+	reply, err := genAutoReply(rule, &msg)
+	if err != nil {
+		log.Errorf("genAutoReply err, id %d, %v", rule.ID, err)
+		return false
+	}
+	log.Infof("auto reply: appid %s, rule %d", appid, rule.ID)
+	c.JSON(http.StatusOK, reply)
+	return true
+}
+
+func matchAutoReplyRule(appid string, msg *wxAutoReplyMsg) (*model.WxAutoReplyRule, error) {
+	if msg.MsgType == "event" && msg.Event == "subscribe" {
+		rules, err := dao.GetAutoReplyRulesWithCache(appid, model.AUTOREPLYTYPE_SUBSCRIBE)
+		if err != nil || len(rules) == 0 {
+			return nil, err
+		}
+		return rules[0], nil
+	}
+	if msg.MsgType != "text" {
+		return nil, nil
+	}
+	rules, err := dao.GetAutoReplyRulesWithCache(appid, model.AUTOREPLYTYPE_KEYWORD)
+	if err != nil {
+		return nil, err
+	}
+	// 同一appid范围内 精确匹配优先于前缀匹配 前缀匹配优先于正则
+	var hit *model.WxAutoReplyRule
+	for _, v := range rules {
+		if hit != nil && (hit.Appid != v.Appid || hit.MatchType <= v.MatchType) {
+			continue
+		}
+		if v.MatchKeyword(msg.Content) {
+			hit = v
+		}
+	}
+	return hit, nil
+}
+
+// genAutoReply 生成json格式的被动回复 xml模式下由msgCryptMiddleWare转换
+func genAutoReply(rule *model.WxAutoReplyRule, msg *wxAutoReplyMsg) (gin.H, error) {
+	var info model.AutoReplyInfo
+	if rule.Info != "" {
+		if err := json.Unmarshal([]byte(rule.Info), &info); err != nil {
+			return nil, err
+		}
+	}
+	reply := gin.H{
+		"ToUserName":   msg.FromUserName,
+		"FromUserName": msg.ToUserName,
+		"CreateTime":   time.Now().Unix(),
+	}
+	switch rule.ReplyType {
+	case model.AUTOREPLY_TEXT:
+		reply["MsgType"] = "text"
+		reply["Content"] = info.Content
+	case model.AUTOREPLY_IMAGE:
+		reply["MsgType"] = "image"
+		reply["Image"] = gin.H{"MediaId": info.MediaId}
+	case model.AUTOREPLY_NEWS:
+		articles := make([]gin.H, 0, len(info.Articles))
+		for _, v := range info.Articles {
+			articles = append(articles, gin.H{
+				"Title":       v.Title,
+				"Description": v.Description,
+				"PicUrl":      v.PicUrl,
+				"Url":         v.Url,
+			})
+		}
+		reply["MsgType"] = "news"
+		reply["ArticleCount"] = len(articles)
+		reply["Articles"] = articles
+	case model.AUTOREPLY_TRANSFER:
+		reply["MsgType"] = "transfer_customer_service"
+	default:
+		return nil, errors.New("回复类型错误")
+	}
+	return reply, nil
+}
@@ -15,10 +15,11 @@ import (
 )
 
 type wxCallbackBizRecord struct {
-	CreateTime int64  `json:"CreateTime"`
-	ToUserName string `json:"ToUserName"`
-	MsgType    string `json:"MsgType"`
-	Event      string `json:"Event"`
+	CreateTime   int64  `json:"CreateTime"`
+	ToUserName   string `json:"ToUserName"`
+	FromUserName string `json:"FromUserName"`
+	MsgType      string `json:"MsgType"`
+	Event        string `json:"Event"`
 }
 
 func bizHandler(c *gin.Context) {
@@ -29,31 +30,52 @@ func bizHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
+	dedupKey := genBizDedupKey(c.Param("appid"), body)
+	if isDuplicateMsg(dedupKey, dao.IncBizCallBackDupCount) {
+		c.String(http.StatusOK, "success")
+		return
+	}
 	r := model.WxCallbackBizRecord{
-		CreateTime:  time.Unix(json.CreateTime, 0),
-		ReceiveTime: time.Now(),
-		Appid:       c.Param("appid"),
-		ToUserName:  json.ToUserName,
-		MsgType:     json.MsgType,
-		Event:       json.Event,
-		PostBody:    string(body),
+		CreateTime:   time.Unix(json.CreateTime, 0),
+		ReceiveTime:  time.Now(),
+		Appid:        c.Param("appid"),
+		ToUserName:   json.ToUserName,
+		FromUserName: json.FromUserName,
+		MsgType:      json.MsgType,
+		Event:        json.Event,
+		PostBody:     string(body),
+		DedupKey:     dedupKey,
 	}
 	if json.CreateTime == 0 {
 		r.CreateTime = time.Unix(1, 0)
 	}
 	if err := dao.AddBizCallBackRecord(&r); err != nil {
+		releaseDedupKey(dedupKey)
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
 
+	if json.MsgType == "event" && json.Event == model.THIRDNOTIFY_NICKNAMEAUDIT {
+		if err := nicknameAuditHandler(c.Param("appid"), &body); err != nil {
+			log.Error(err)
+		}
+	}
+	if json.MsgType == "event" && (json.Event == model.WEAPPAUDIT_SUCCESS ||
+		json.Event == model.WEAPPAUDIT_FAIL || json.Event == model.WEAPPAUDIT_DELAY) {
+		if err := weappAuditHandler(c.Param("appid"), &body); err != nil {
+			log.Error(err)
+		}
+	}
+
 	// 转发到用户配置的地址
 	proxyOpen, err := proxyCallbackMsg("", json.MsgType, json.Event, string(body), c)
 	if err != nil {
 		log.Error(err)
+		releaseDedupKey(dedupKey)
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
-	if !proxyOpen {
+	if !proxyOpen && !autoReply(c.Param("appid"), body, c) {
 		c.String(http.StatusOK, "success")
 	}
 }
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
@@ -17,7 +19,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+func newReverseProxy(target *url.URL, record *model.WxCallbackDeliveryRecord) *httputil.ReverseProxy {
 	targetQuery := target.RawQuery
 	director := func(req *http.Request) {
 		req.URL.Scheme = target.Scheme
@@ -33,38 +35,214 @@ func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
 			req.Header.Set("User-Agent", "")
 		}
 	}
+	modifyResponse := func(resp *http.Response) error {
+		record.StatusCode = resp.StatusCode
+		return nil
+	}
 	errorHandler := func(rw http.ResponseWriter, req *http.Request, err error) {
 		log.Errorf("http: proxy error: %v", err)
+		record.ErrMsg = err.Error()
 		result, _ := json.Marshal(errno.ErrSystemError.WithData(err.Error()))
 		rw.Header().Set("Content-Type", "application/json")
 		rw.Write([]byte(result))
 	}
-	return &httputil.ReverseProxy{Director: director, ErrorHandler: errorHandler}
+	return &httputil.ReverseProxy{Director: director, ModifyResponse: modifyResponse, ErrorHandler: errorHandler}
+}
+
+func genTargetUrl(target model.HttpProxyTarget, appid string) (*url.URL, error) {
+	path := strings.Replace(target.Path, "$APPID$", appid, -1)
+	return url.Parse(fmt.Sprintf("http://127.0.0.1:%d%s", target.Port, path))
+}
+
+func newDeliveryRecord(rule *model.WxCallbackRule, appid string, target string,
+	primary bool) *model.WxCallbackDeliveryRecord {
+	record := &model.WxCallbackDeliveryRecord{
+		RuleID:     rule.ID,
+		Appid:      appid,
+		InfoType:   rule.InfoType,
+		MsgType:    rule.MsgType,
+		Event:      rule.Event,
+		Target:     target,
+		CreateTime: time.Now(),
+	}
+	if primary {
+		record.Primary = 1
+	}
+	return record
+}
+
+func finishDeliveryRecord(record *model.WxCallbackDeliveryRecord, begin time.Time) {
+	record.Latency = time.Since(begin).Milliseconds()
+	// 文件、命令等非http目标没有状态码
+	if record.ErrMsg == "" && (record.StatusCode == http.StatusOK || record.StatusCode == 0) {
+		record.Result = model.DELIVERYRESULT_SUCC
+	} else {
+		record.Result = model.DELIVERYRESULT_FAIL
+		if record.ErrMsg == "" {
+			record.ErrMsg = fmt.Sprintf("http code: %d", record.StatusCode)
+		}
+	}
+	if err := dao.AddCallBackDeliveryRecord(record); err != nil {
+		log.Errorf("AddCallBackDeliveryRecord err %v", err)
+	}
+}
+
+// deliverHttp 投递消息到http目标 结果写入record 不影响给微信的回包
+func deliverHttp(record *model.WxCallbackDeliveryRecord, target *url.URL,
+	header http.Header, rawQuery string, body string) {
+	if target.RawQuery == "" || rawQuery == "" {
+		target.RawQuery = target.RawQuery + rawQuery
+	} else {
+		target.RawQuery = target.RawQuery + "&" + rawQuery
+	}
+	req, err := http.NewRequest(http.MethodPost, target.String(), bytes.NewBufferString(body))
+	if err != nil {
+		record.ErrMsg = err.Error()
+		return
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Del("Content-Length")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("deliver error: %v", err)
+		record.ErrMsg = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	record.StatusCode = resp.StatusCode
+}
+
+// ParseCallbackMsg 解析推送消息 用于匹配规则的内容条件 数值保留为json.Number
+func ParseCallbackMsg(body []byte) map[string]interface{} {
+	msg := make(map[string]interface{})
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&msg); err != nil {
+		log.Errorf("Decode err, %v", err)
+	}
+	return msg
 }
 
 func proxyCallbackMsg(infoType string, msgType string, event string, body string, c *gin.Context) (bool, error) {
-	rule, err := dao.GetWxCallBackRuleWithCache(infoType, msgType, event)
+	msg := ParseCallbackMsg([]byte(body))
+	rule, err := dao.GetWxCallBackRuleWithCache(c.Param("appid"), infoType, msgType, event, msg)
 	if err != nil {
 		log.Error(err)
 		return false, err
 	}
-	if rule != nil && rule.Open != 0 && rule.Type == model.PROXYTYPE_HTTP {
-		var proxyConfig model.HttpProxyConfig
-		if err = json.Unmarshal([]byte(rule.Info), &proxyConfig); err != nil {
+	if rule == nil || rule.Open == 0 {
+		return false, nil
+	}
+	if err = deliverCallbackMsg(rule, body, msg, c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deliverCallbackMsg 按规则改写并转发消息 给微信的回包写入c
+func deliverCallbackMsg(rule *model.WxCallbackRule, body string, msg map[string]interface{}, c *gin.Context) error {
+	body, err := TransformCallbackMsg(rule, c.Param("appid"), body, msg)
+	if err != nil {
+		return err
+	}
+	if rule.Async != 0 && AsyncEnabled() {
+		// 异步模式 先写入投递队列再回复微信
+		if err = enqueueCallbackMsg(rule, c.Param("appid"), c.Request.Header,
+			c.Request.URL.RawQuery, body); err != nil {
+			return err
+		}
+		c.String(http.StatusOK, "success")
+		return nil
+	}
+	if deadline := getReplyDeadline(rule); deadline > 0 {
+		return deliverWithDeadline(rule, body, c, deadline)
+	}
+	return deliverSyncMsg(rule, body, c)
+}
+
+// deliverSyncMsg 同步转发 等待目标回包后写入c
+func deliverSyncMsg(rule *model.WxCallbackRule, body string, c *gin.Context) error {
+	switch rule.Type {
+	case model.PROXYTYPE_HTTP:
+		return proxyHttp(rule, body, c)
+	case model.PROXYTYPE_WEBHOOK:
+		var webhook model.WebhookConfig
+		if err := json.Unmarshal([]byte(rule.Info), &webhook); err != nil {
 			log.Errorf("Unmarshal err, %v", err)
-			return false, err
+			return err
 		}
-		path := strings.Replace(proxyConfig.Path, "$APPID$", c.Param("appid"), -1)
-		log.Infof("proxy: %v, real path %s", rule, path)
-		var target *url.URL
-		if target, err = url.Parse(fmt.Sprintf("http://127.0.0.1:%d%s", proxyConfig.Port, path)); err != nil {
+		log.Infof("webhook: %v", rule)
+		proxyWebhook(rule, &webhook, body, c)
+		return nil
+	}
+	// 其余转发方式投递完成后回复success
+	sinks, err := NewSinks(rule, c.Param("appid"))
+	if err != nil {
+		return err
+	}
+	log.Infof("sink: %v, targets %d", rule, len(sinks))
+	sinkMsg := &SinkMsg{
+		Appid:    c.Param("appid"),
+		Header:   c.Request.Header,
+		RawQuery: c.Request.URL.RawQuery,
+		Body:     body,
+	}
+	for i, sink := range sinks {
+		deliverToSink(rule, sink, i == 0, sinkMsg)
+	}
+	c.String(http.StatusOK, "success")
+	return nil
+}
+
+func genHttpTargetUrls(rule *model.WxCallbackRule, appid string) ([]*url.URL, error) {
+	var proxyConfig model.HttpProxyConfig
+	if err := json.Unmarshal([]byte(rule.Info), &proxyConfig); err != nil {
+		log.Errorf("Unmarshal err, %v", err)
+		return nil, err
+	}
+	targets := proxyConfig.GetTargets()
+	urls := make([]*url.URL, len(targets))
+	for i, v := range targets {
+		var err error
+		if urls[i], err = genTargetUrl(v, appid); err != nil {
 			log.Errorf("url Parse error: %v", err)
-			return false, err
+			return nil, err
 		}
-		proxy := newReverseProxy(target)
-		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer([]byte(body)))
-		proxy.ServeHTTP(c.Writer, c.Request)
-		return true, nil
 	}
-	return false, nil
+	return urls, nil
+}
+
+// proxyHttp 转发到容器内的http服务 主目标的回包返回给微信 其余目标异步投递副本
+func proxyHttp(rule *model.WxCallbackRule, body string, c *gin.Context) error {
+	appid := c.Param("appid")
+	urls, err := genHttpTargetUrls(rule, appid)
+	if err != nil {
+		return err
+	}
+	log.Infof("proxy: %v, real path %s, copies %d", rule, urls[0].Path, len(urls)-1)
+
+	for _, target := range urls[1:] {
+		go func(record *model.WxCallbackDeliveryRecord, target *url.URL, header http.Header, rawQuery string) {
+			begin := time.Now()
+			deliverHttp(record, target, header, rawQuery, body)
+			finishDeliveryRecord(record, begin)
+		}(newDeliveryRecord(rule, appid, target.String(), false), target,
+			c.Request.Header.Clone(), c.Request.URL.RawQuery)
+	}
+
+	record := newDeliveryRecord(rule, appid, urls[0].String(), true)
+	begin := time.Now()
+	proxy := newReverseProxy(urls[0], record)
+	// 改写后内容长度可能变化
+	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer([]byte(body)))
+	c.Request.ContentLength = int64(len(body))
+	c.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	proxy.ServeHTTP(c.Writer, c.Request)
+	markDeliveryFinished(c, record)
+	go finishDeliveryRecord(record, begin)
+	return nil
 }
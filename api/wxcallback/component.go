@@ -29,16 +29,23 @@ func componentHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
 		return
 	}
+	dedupKey := genComponentDedupKey(body)
+	if isDuplicateMsg(dedupKey, dao.IncComponentCallBackDupCount) {
+		c.String(http.StatusOK, "success")
+		return
+	}
 	r := model.WxCallbackComponentRecord{
 		CreateTime:  time.Unix(json.CreateTime, 0),
 		ReceiveTime: time.Now(),
 		InfoType:    json.InfoType,
 		PostBody:    string(body),
+		DedupKey:    dedupKey,
 	}
 	if json.CreateTime == 0 {
 		r.CreateTime = time.Unix(1, 0)
 	}
 	if err := dao.AddComponentCallBackRecord(&r); err != nil {
+		releaseDedupKey(dedupKey)
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
@@ -54,9 +61,14 @@ func componentHandler(c *gin.Context) {
 		err = newAuthHander(&body)
 	case "unauthorized":
 		err = unAuthHander(&body)
+	case model.THIRDNOTIFY_FASTREGISTER:
+		err = fastRegisterHandler(&body)
+	case model.THIRDNOTIFY_FASTVERIFYBETAAPP:
+		err = fastVerifyBetaAppHandler(&body)
 	}
 	if err != nil {
 		log.Error(err)
+		releaseDedupKey(dedupKey)
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
@@ -66,6 +78,7 @@ func componentHandler(c *gin.Context) {
 	proxyOpen, err = proxyCallbackMsg(json.InfoType, "", "", string(body), c)
 	if err != nil {
 		log.Error(err)
+		releaseDedupKey(dedupKey)
 		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
 		return
 	}
@@ -99,20 +112,41 @@ type newAuthRecord struct {
 
 func newAuthHander(body *[]byte) error {
 	var record newAuthRecord
+	if err := binding.JSON.BindBody(*body, &record); err != nil {
+		return err
+	}
+	return saveAuthorizer(record.AuthorizerAppid, record.AuthorizationCode, time.Unix(record.CreateTime, 0))
+}
+
+// saveAuthorizer 通过授权码换取refreshtoken并保存授权账号信息
+func saveAuthorizer(appid string, authCode string, authTime time.Time) error {
 	var err error
 	var refreshtoken string
 	var appinfo wx.AuthorizerInfoResp
-	if err = binding.JSON.BindBody(*body, &record); err != nil {
+	if refreshtoken, err = queryAuth(authCode); err != nil {
 		return err
 	}
-	if refreshtoken, err = queryAuth(record.AuthorizationCode); err != nil {
+	if err = wx.GetAuthorizerInfo(appid, &appinfo); err != nil {
 		return err
 	}
-	if err = wx.GetAuthorizerInfo(record.AuthorizerAppid, &appinfo); err != nil {
+	record := genAuthorizerRecord(appid, &appinfo)
+	record.RefreshToken = refreshtoken
+	record.AuthTime = authTime
+	return dao.CreateOrUpdateAuthorizerRecord(record)
+}
+
+// refreshAuthorizerInfo 重新拉取并更新授权账号信息
+func refreshAuthorizerInfo(appid string) error {
+	var appinfo wx.AuthorizerInfoResp
+	if err := wx.GetAuthorizerInfo(appid, &appinfo); err != nil {
 		return err
 	}
-	if err = dao.CreateOrUpdateAuthorizerRecord(&model.Authorizer{
-		Appid:         record.AuthorizerAppid,
+	return dao.UpdateAuthorizerInfo(genAuthorizerRecord(appid, &appinfo))
+}
+
+func genAuthorizerRecord(appid string, appinfo *wx.AuthorizerInfoResp) *model.Authorizer {
+	return &model.Authorizer{
+		Appid:         appid,
 		AppType:       appinfo.AuthorizerInfo.AppType,
 		ServiceType:   appinfo.AuthorizerInfo.ServiceType.Id,
 		NickName:      appinfo.AuthorizerInfo.NickName,
@@ -120,14 +154,9 @@ func newAuthHander(body *[]byte) error {
 		HeadImg:       appinfo.AuthorizerInfo.HeadImg,
 		QrcodeUrl:     appinfo.AuthorizerInfo.QrcodeUrl,
 		PrincipalName: appinfo.AuthorizerInfo.PrincipalName,
-		RefreshToken:  refreshtoken,
 		FuncInfo:      appinfo.AuthorizationInfo.StrFuncInfo,
 		VerifyInfo:    appinfo.AuthorizerInfo.VerifyInfo.Id,
-		AuthTime:      time.Unix(record.CreateTime, 0),
-	}); err != nil {
-		return err
 	}
-	return nil
 }
 
 type queryAuthReq struct {
@@ -171,8 +200,10 @@ func unAuthHander(body *[]byte) error {
 		log.Errorf("bind err %v", err)
 		return err
 	}
-	if err := dao.DelAuthorizerRecord(record.AuthorizerAppid); err != nil {
-		log.Errorf("DelAuthorizerRecord err %v", err)
+	// 保留记录并标记为已取消授权 便于确认需要重新授权的账号 重新拉取授权列表时删除
+	if err := dao.UpdateAuthorizerHealth(record.AuthorizerAppid, model.AUTHORIZERHEALTH_UNAUTHORIZED,
+		"取消授权"); err != nil {
+		log.Errorf("UpdateAuthorizerHealth err %v", err)
 		return err
 	}
 	return nil
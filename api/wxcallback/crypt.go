@@ -0,0 +1,304 @@
+package wxcallback
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	wxbase "github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/base"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/msgcrypt"
+	"github.com/gin-gonic/gin"
+)
+
+// 开放平台标准模式：推送内容为xml，可能经过aes加密
+// 解密后转成json交给原有的处理逻辑，回包时再转回xml并加密
+
+type encryptedMsg struct {
+	ToUserName string `xml:"ToUserName"`
+	AppId      string `xml:"AppId"`
+	Encrypt    string `xml:"Encrypt"`
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+type encryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      cdata    `xml:"Encrypt"`
+	MsgSignature cdata    `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        cdata    `xml:"Nonce"`
+}
+
+// replyWriter 缓存处理逻辑的回包 便于转换格式后再写回
+type replyWriter struct {
+	gin.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (w *replyWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *replyWriter) WriteHeaderNow() {}
+
+func (w *replyWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *replyWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *replyWriter) Flush() {}
+
+func isXmlMode(c *gin.Context) bool {
+	return c.Query("encrypt_type") == "aes" ||
+		strings.Contains(strings.ToLower(c.GetHeader("Content-Type")), "xml")
+}
+
+func newMsgCrypt() (*msgcrypt.MsgCrypt, error) {
+	if config.WxCallbackConf.EncodingAESKey == "" {
+		return nil, errors.New("empty EncodingAESKey")
+	}
+	return msgcrypt.NewMsgCrypt(config.WxCallbackConf.Token,
+		config.WxCallbackConf.EncodingAESKey, wxbase.GetAppid())
+}
+
+// msgCryptMiddleWare 中间件 xml推送解密并转换为json
+func msgCryptMiddleWare(c *gin.Context) {
+	if !isXmlMode(c) {
+		c.Next()
+		return
+	}
+	body, _ := ioutil.ReadAll(c.Request.Body)
+	encrypted := c.Query("encrypt_type") == "aes"
+	plain := body
+	if encrypted {
+		var msg encryptedMsg
+		if err := xml.Unmarshal(body, &msg); err != nil {
+			log.Errorf("Unmarshal err, %v", err)
+			c.Abort()
+			c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+			return
+		}
+		crypt, err := newMsgCrypt()
+		if err != nil {
+			log.Error(err)
+			c.Abort()
+			c.JSON(http.StatusOK, errno.ErrSystemError.WithData(err.Error()))
+			return
+		}
+		if plain, err = crypt.Decrypt(c.Query("msg_signature"), c.Query("timestamp"),
+			c.Query("nonce"), msg.Encrypt); err != nil {
+			log.Errorf("decrypt err, %v", err)
+			c.Abort()
+			c.JSON(http.StatusOK, errno.ErrNotAuthorized.WithData(err.Error()))
+			return
+		}
+	}
+	jsonBody, err := xmlToJson(plain)
+	if err != nil {
+		log.Errorf("xmlToJson err, %v", err)
+		c.Abort()
+		c.JSON(http.StatusOK, errno.ErrInvalidParam.WithData(err.Error()))
+		return
+	}
+	log.Debugf("xml body: %s", string(jsonBody))
+	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(jsonBody))
+	c.Request.ContentLength = int64(len(jsonBody))
+	c.Request.Header.Set("Content-Length", strconv.Itoa(len(jsonBody)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	writer := &replyWriter{ResponseWriter: c.Writer, status: http.StatusOK, body: &bytes.Buffer{}}
+	c.Writer = writer
+	c.Next()
+	c.Writer = writer.ResponseWriter
+	writeXmlReply(c, writer.status, writer.body.Bytes(), encrypted)
+}
+
+// writeXmlReply 被动回复 json转为xml 加密模式下再进行加密
+func writeXmlReply(c *gin.Context, status int, reply []byte, encrypted bool) {
+	trimmed := bytes.TrimSpace(reply)
+	var replyXml []byte
+	if len(trimmed) == 0 || string(trimmed) == "success" {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.String(http.StatusOK, "success")
+		return
+	} else if trimmed[0] == '<' {
+		replyXml = trimmed
+	} else if trimmed[0] == '{' && bytes.Contains(trimmed, []byte(`"MsgType"`)) {
+		var err error
+		if replyXml, err = jsonToXml(trimmed); err != nil {
+			log.Errorf("jsonToXml err, %v", err)
+		}
+	}
+	if replyXml == nil {
+		// 非被动回复 比如错误信息 原样返回
+		c.Writer.WriteHeader(status)
+		c.Writer.Write(reply)
+		return
+	}
+	if encrypted {
+		crypt, err := newMsgCrypt()
+		if err != nil {
+			log.Error(err)
+			c.String(http.StatusOK, "success")
+			return
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := c.Query("nonce")
+		encrypt, signature, err := crypt.Encrypt(replyXml, timestamp, nonce)
+		if err != nil {
+			log.Errorf("encrypt err, %v", err)
+			c.String(http.StatusOK, "success")
+			return
+		}
+		if replyXml, err = xml.Marshal(encryptedReply{
+			Encrypt:      cdata{encrypt},
+			MsgSignature: cdata{signature},
+			TimeStamp:    timestamp,
+			Nonce:        cdata{nonce},
+		}); err != nil {
+			log.Errorf("Marshal err, %v", err)
+			c.String(http.StatusOK, "success")
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", replyXml)
+}
+
+// numberRegexp 合法的json数字 带前导零的值如007保留为字符串
+var numberRegexp = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?$`)
+
+// xmlToJson 微信推送的xml转换成json 非CDATA的数值字段转换为数字
+func xmlToJson(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("empty xml")
+			}
+			return nil, err
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			value, err := decodeXmlElement(d, data)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := value.(map[string]interface{}); !ok {
+				value = map[string]interface{}{}
+			}
+			return json.Marshal(value)
+		}
+	}
+}
+
+func decodeXmlElement(d *xml.Decoder, data []byte) (interface{}, error) {
+	children := map[string]interface{}{}
+	var text strings.Builder
+	isCData := false
+	for {
+		offset := d.InputOffset()
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			value, err := decodeXmlElement(d, data)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			if old, ok := children[name]; ok {
+				if list, ok := old.([]interface{}); ok {
+					children[name] = append(list, value)
+				} else {
+					children[name] = []interface{}{old, value}
+				}
+			} else {
+				children[name] = value
+			}
+		case xml.CharData:
+			if bytes.Contains(data[offset:d.InputOffset()], []byte("<![CDATA[")) {
+				isCData = true
+			}
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) != 0 {
+				return children, nil
+			}
+			if isCData {
+				return text.String(), nil
+			}
+			value := strings.TrimSpace(text.String())
+			if numberRegexp.MatchString(value) {
+				return json.Number(value), nil
+			}
+			return value, nil
+		}
+	}
+}
+
+// jsonToXml json格式的被动回复转换为xml 数组元素以item包裹
+func jsonToXml(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var value map[string]interface{}
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<xml>")
+	encodeXmlMap(&buf, value)
+	buf.WriteString("</xml>")
+	return buf.Bytes(), nil
+}
+
+func encodeXmlMap(buf *bytes.Buffer, value map[string]interface{}) {
+	keys := make([]string, 0, len(value))
+	for k := range value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		encodeXmlElement(buf, k, value[k])
+	}
+}
+
+func encodeXmlElement(buf *bytes.Buffer, name string, value interface{}) {
+	buf.WriteString("<" + name + ">")
+	switch v := value.(type) {
+	case map[string]interface{}:
+		encodeXmlMap(buf, v)
+	case []interface{}:
+		for _, item := range v {
+			encodeXmlElement(buf, "item", item)
+		}
+	case json.Number:
+		buf.WriteString(v.String())
+	case string:
+		buf.WriteString("<![CDATA[" + strings.Replace(v, "]]>", "]]]]><![CDATA[>", -1) + "]]>")
+	case nil:
+	default:
+		buf.WriteString(fmt.Sprint(v))
+	}
+	buf.WriteString("</" + name + ">")
+}
@@ -0,0 +1,75 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestXmlToJson(t *testing.T) {
+	data := []byte(`<xml>
+<ToUserName><![CDATA[gh_123]]></ToUserName>
+<CreateTime>1409304348</CreateTime>
+<MsgType><![CDATA[text]]></MsgType>
+<Content><![CDATA[123]]></Content>
+<MsgId>-12.5</MsgId>
+<Code>007</Code>
+<Zero>0</Zero>
+<List><Item>1</Item><Item>2</Item></List>
+</xml>`)
+	result, err := xmlToJson(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Code":"007","Content":"123","CreateTime":1409304348,"List":{"Item":[1,2]},` +
+		`"MsgId":-12.5,"MsgType":"text","ToUserName":"gh_123","Zero":0}`
+	if string(result) != want {
+		t.Errorf("xmlToJson = %s\nwant %s", result, want)
+	}
+}
+
+func TestXmlToJsonError(t *testing.T) {
+	for _, data := range []string{"", "<xml><a></xml>"} {
+		if _, err := xmlToJson([]byte(data)); err == nil {
+			t.Errorf("xmlToJson(%q) want error", data)
+		}
+	}
+	result, err := xmlToJson([]byte("<xml>text</xml>"))
+	if err != nil || string(result) != "{}" {
+		t.Errorf("xmlToJson = %s, err %v", result, err)
+	}
+}
+
+func TestJsonToXml(t *testing.T) {
+	result, err := jsonToXml([]byte(`{"ToUserName":"gh_123","CreateTime":1409304348,"Content":"a]]>b",` +
+		`"Articles":[{"Title":"t"}],"Empty":null,"Bool":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<xml><Articles><item><Title><![CDATA[t]]></Title></item></Articles><Bool>true</Bool>` +
+		`<Content><![CDATA[a]]]]><![CDATA[>b]]></Content><CreateTime>1409304348</CreateTime><Empty></Empty>` +
+		`<ToUserName><![CDATA[gh_123]]></ToUserName></xml>`
+	if string(result) != want {
+		t.Errorf("jsonToXml = %s\nwant %s", result, want)
+	}
+	if _, err = jsonToXml([]byte("[]")); err == nil {
+		t.Error("want error for non-object json")
+	}
+}
+
+func TestXmlJsonRoundTrip(t *testing.T) {
+	data := []byte(`{"Content":"007","CreateTime":1409304348,"MsgType":"text"}`)
+	xmlData, err := jsonToXml(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := xmlToJson(xmlData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	json.Unmarshal(result, &got)
+	json.Unmarshal(data, &want)
+	if len(got) != len(want) || got["Content"] != "007" || got["CreateTime"] != want["CreateTime"] {
+		t.Errorf("round trip = %s", result)
+	}
+}
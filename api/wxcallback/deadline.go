@@ -0,0 +1,133 @@
+package wxcallback
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 回复期限：微信等待回包5秒后会重试 同步转发的目标超过期限未回包时网关先回复success
+// 转发在后台继续进行 迟到的回包记录在转发记录中
+
+const replyDeadlineKey = "wxcallback_reply_deadline"
+
+// lateDeliveryTimeout 超过回复期限后在后台继续等待目标回包的最长时间
+const lateDeliveryTimeout = time.Minute
+
+// lateResponseLimit 转发记录中保存的迟到回包的最大长度
+const lateResponseLimit = 4096
+
+type replyDeadline struct {
+	mutex    sync.Mutex
+	expired  bool
+	finished bool
+	recorder *responseRecorder
+}
+
+// expire 到达期限 转发已完成时返回false
+func (d *replyDeadline) expire() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.finished {
+		return false
+	}
+	d.expired = true
+	return true
+}
+
+// finish 转发完成 返回是否已超过期限
+func (d *replyDeadline) finish() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.finished = true
+	return d.expired
+}
+
+// getReplyDeadline 规则等待回包的期限 为0时不限制
+func getReplyDeadline(rule *model.WxCallbackRule) time.Duration {
+	deadline := rule.ReplyDeadline
+	if deadline == 0 {
+		deadline = config.WxCallbackConf.ReplyDeadline
+	}
+	if deadline <= 0 {
+		return 0
+	}
+	return time.Duration(deadline) * time.Millisecond
+}
+
+// markDeliveryFinished 目标已回包 超过回复期限时把回包记录到转发记录中
+func markDeliveryFinished(c *gin.Context, record *model.WxCallbackDeliveryRecord) {
+	value, ok := c.Get(replyDeadlineKey)
+	if !ok {
+		return
+	}
+	d := value.(*replyDeadline)
+	if !d.finish() {
+		return
+	}
+	record.Late = 1
+	record.Response = d.recorder.Body.String()
+	if len(record.Response) > lateResponseLimit {
+		record.Response = record.Response[:lateResponseLimit]
+	}
+}
+
+// deliverWithDeadline 在独立的请求中同步转发 期限内完成时把目标的回包写入c 否则先回复success
+func deliverWithDeadline(rule *model.WxCallbackRule, body string, c *gin.Context, deadline time.Duration) error {
+	// 后台转发不能使用原请求的context 回复微信后原请求即结束
+	ctx, cancel := context.WithTimeout(context.Background(), lateDeliveryTimeout)
+	recorder := newResponseRecorder()
+	dc, _ := gin.CreateTestContext(recorder)
+	dc.Request = c.Request.Clone(ctx)
+	dc.Params = c.Params
+	d := &replyDeadline{recorder: recorder}
+	dc.Set(replyDeadlineKey, d)
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		// 后台协程panic会导致进程退出
+		defer func() {
+			if p := recover(); p != nil {
+				log.Errorf("deliver with deadline panic: %v, %v", rule, p)
+				err = fmt.Errorf("deliver panic: %v", p)
+			}
+			cancel()
+			d.finish()
+			done <- err
+		}()
+		err = deliverSyncMsg(rule, body, dc)
+	}()
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return writeRecorder(recorder, err, c)
+	case <-timer.C:
+		if !d.expire() {
+			return writeRecorder(recorder, <-done, c)
+		}
+	}
+	log.Infof("reply deadline exceeded: %v, deadline %v", rule, deadline)
+	c.String(http.StatusOK, "success")
+	return nil
+}
+
+func writeRecorder(recorder *responseRecorder, err error, c *gin.Context) error {
+	if err != nil {
+		return err
+	}
+	for k, v := range recorder.Header() {
+		c.Writer.Header()[k] = v
+	}
+	c.Writer.WriteHeader(recorder.Code)
+	c.Writer.Write(recorder.Body.Bytes())
+	return nil
+}
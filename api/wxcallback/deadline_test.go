@@ -0,0 +1,38 @@
+package wxcallback
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestDeadlineContext() (*gin.Context, *responseRecorder) {
+	recorder := newResponseRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/wxcallback/biz/wxappid", strings.NewReader("{}"))
+	return c, recorder
+}
+
+func TestDeliverWithDeadlineError(t *testing.T) {
+	c, recorder := newTestDeadlineContext()
+	err := deliverWithDeadline(&model.WxCallbackRule{Type: -1}, "{}", c, time.Second)
+	if err == nil || err.Error() != "转发类型错误" {
+		t.Fatalf("err = %v", err)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("unexpected body: %s", recorder.Body.String())
+	}
+}
+
+func TestDeliverWithDeadlineRecoverPanic(t *testing.T) {
+	c, _ := newTestDeadlineContext()
+	// 空规则在后台协程中panic 应作为错误返回而不是导致进程退出
+	err := deliverWithDeadline(nil, "{}", c, time.Second)
+	if err == nil || !strings.HasPrefix(err.Error(), "deliver panic") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package wxcallback
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+)
+
+// 微信回包超时会重试推送 同一消息在去重窗口内只记录和转发一次
+
+type wxCallbackDedupInfo struct {
+	MsgId           json.RawMessage `json:"MsgId"`
+	FromUserName    string          `json:"FromUserName"`
+	CreateTime      int64           `json:"CreateTime"`
+	Event           string          `json:"Event"`
+	InfoType        string          `json:"InfoType"`
+	AuthorizerAppid string          `json:"AuthorizerAppid"`
+}
+
+// genBizDedupKey 有MsgId时使用MsgId 否则使用FromUserName+CreateTime+Event
+func genBizDedupKey(appid string, body []byte) string {
+	var info wxCallbackDedupInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return ""
+	}
+	if msgId := strings.Trim(string(info.MsgId), `"`); msgId != "" && msgId != "null" {
+		return fmt.Sprintf("%s_%s", appid, msgId)
+	}
+	if info.FromUserName == "" || info.CreateTime == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s_%s_%d_%s", appid, info.FromUserName, info.CreateTime, info.Event)
+}
+
+// genComponentDedupKey 授权事件使用InfoType+CreateTime+消息内容的哈希
+// 快速注册等通知没有AuthorizerAppid 同一秒内的不同通知只能通过内容区分
+func genComponentDedupKey(body []byte) string {
+	var info wxCallbackDedupInfo
+	if err := json.Unmarshal(body, &info); err != nil || info.CreateTime == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s_%d_%x", info.InfoType, info.CreateTime, sha1.Sum(body))
+}
+
+// isDuplicateMsg 去重窗口内已收到过相同消息时累计重复次数并返回true 查询出错时按不重复处理
+// 返回false时已登记key 回复微信success前处理失败需调用releaseDedupKey 否则重试推送会被丢弃
+func isDuplicateMsg(dedupKey string, incDupCount func(string, time.Time) (bool, error)) bool {
+	if dedupKey == "" || config.WxCallbackConf.DedupWindow <= 0 {
+		return false
+	}
+	since := time.Now().Add(-time.Duration(config.WxCallbackConf.DedupWindow) * time.Second)
+	dup, err := dao.RegisterCallBackDedupKey(dedupKey, since)
+	if err != nil || !dup {
+		return false
+	}
+	// 首次推送的记录可能还未写入 此时只丢弃不计数
+	incDupCount(dedupKey, since)
+	log.Infof("drop duplicate msg: %s", dedupKey)
+	return true
+}
+
+// releaseDedupKey 消息未能记录或转发 删除登记的key 使微信的重试推送可以重新处理
+func releaseDedupKey(dedupKey string) {
+	if dedupKey == "" || config.WxCallbackConf.DedupWindow <= 0 {
+		return
+	}
+	if err := dao.DelCallBackDedupKey(dedupKey); err != nil {
+		log.Errorf("release dedup key %s err %v", dedupKey, err)
+	}
+}
@@ -0,0 +1,126 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 快速注册、试用小程序快速认证、名称审核等通知的处理 结果记录在wxthird_notify
+
+// jsonString xml转换的json中纯数字的字段会变成数值 统一按字符串解析
+type jsonString string
+
+func (s *jsonString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		*s = jsonString(strings.TrimSpace(string(data)))
+		return nil
+	}
+	*s = jsonString(str)
+	return nil
+}
+
+type fastRegisterInfo struct {
+	Name               jsonString `json:"name"`
+	Code               jsonString `json:"code"`
+	LegalPersonaWechat jsonString `json:"legal_persona_wechat"`
+	LegalPersonaName   jsonString `json:"legal_persona_name"`
+}
+
+// 通知中同时有AppId(第三方平台appid)和appid(小程序appid) 需分别声明避免大小写不敏感匹配
+type fastRegisterRecord struct {
+	CreateTime     int64           `json:"CreateTime"`
+	ComponentAppid string          `json:"AppId"`
+	Appid          string          `json:"appid"`
+	Status         int             `json:"status"`
+	AuthCode       string          `json:"auth_code"`
+	Msg            jsonString      `json:"msg"`
+	Info           json.RawMessage `json:"info"`
+}
+
+// fastRegisterHandler 快速注册企业小程序结果 成功时保存新小程序的授权信息
+func fastRegisterHandler(body *[]byte) error {
+	var record fastRegisterRecord
+	if err := binding.JSON.BindBody(*body, &record); err != nil {
+		log.Errorf("bind err %v", err)
+		return err
+	}
+	var info fastRegisterInfo
+	if len(record.Info) != 0 {
+		json.Unmarshal(record.Info, &info)
+	}
+	if record.Status == 0 && record.Appid != "" && record.AuthCode != "" {
+		if err := saveAuthorizer(record.Appid, record.AuthCode, time.Unix(record.CreateTime, 0)); err != nil {
+			log.Errorf("saveAuthorizer err %v", err)
+		}
+	}
+	// 同一企业的多次注册结果更新同一条记录
+	return dao.CreateOrUpdateThirdNotify(&model.WxThirdNotify{
+		InfoType: model.THIRDNOTIFY_FASTREGISTER,
+		Appid:    record.Appid,
+		UniqKey:  string(info.Name) + "_" + string(info.Code) + "_" + string(info.LegalPersonaWechat),
+		Status:   record.Status,
+		Msg:      string(record.Msg),
+		Info:     string(record.Info),
+	})
+}
+
+// fastVerifyBetaAppHandler 试用小程序快速认证结果 成功时更新授权账号信息
+func fastVerifyBetaAppHandler(body *[]byte) error {
+	var record fastRegisterRecord
+	if err := binding.JSON.BindBody(*body, &record); err != nil {
+		log.Errorf("bind err %v", err)
+		return err
+	}
+	if record.Status == 0 && record.Appid != "" {
+		if err := refreshAuthorizerInfo(record.Appid); err != nil {
+			log.Errorf("refreshAuthorizerInfo err %v", err)
+		}
+	}
+	return dao.CreateOrUpdateThirdNotify(&model.WxThirdNotify{
+		InfoType: model.THIRDNOTIFY_FASTVERIFYBETAAPP,
+		Appid:    record.Appid,
+		UniqKey:  record.Appid,
+		Status:   record.Status,
+		Msg:      string(record.Msg),
+		Info:     string(record.Info),
+	})
+}
+
+type nicknameAuditRecord struct {
+	Ret      int        `json:"ret"`
+	Nickname jsonString `json:"nickname"`
+	Reason   jsonString `json:"reason"`
+}
+
+// nicknameAuditHandler 名称审核结果 推送到消息与事件URL 审核通过时更新授权账号信息
+func nicknameAuditHandler(appid string, body *[]byte) error {
+	var record nicknameAuditRecord
+	if err := binding.JSON.BindBody(*body, &record); err != nil {
+		log.Errorf("bind err %v", err)
+		return err
+	}
+	if record.Ret == model.NICKNAMEAUDIT_PASS {
+		if err := refreshAuthorizerInfo(appid); err != nil {
+			log.Errorf("refreshAuthorizerInfo err %v", err)
+		}
+	}
+	info, _ := json.Marshal(map[string]string{"nickname": string(record.Nickname)})
+	return dao.CreateOrUpdateThirdNotify(&model.WxThirdNotify{
+		InfoType: model.THIRDNOTIFY_NICKNAMEAUDIT,
+		Appid:    appid,
+		UniqKey:  appid + "_" + string(record.Nickname),
+		Status:   record.Ret,
+		Msg:      string(record.Reason),
+		Info:     string(info),
+	})
+}
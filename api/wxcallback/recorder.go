@@ -0,0 +1,25 @@
+package wxcallback
+
+import (
+	"net/http/httptest"
+)
+
+// responseRecorder 记录回包 用于测试转发和后台转发构造的gin.Context
+// gin的ResponseWriter把CloseNotify转给底层writer 底层不是CloseNotifier时会panic
+// Go1.17的ReverseProxy总会调用CloseNotify 转发的取消由请求的context控制 这里的通知永远不会触发
+type responseRecorder struct {
+	*httptest.ResponseRecorder
+	closeNotify chan bool
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		closeNotify:      make(chan bool, 1),
+	}
+}
+
+// CloseNotify 实现http.CloseNotifier
+func (r *responseRecorder) CloseNotify() <-chan bool {
+	return r.closeNotify
+}
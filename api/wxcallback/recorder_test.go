@@ -0,0 +1,50 @@
+package wxcallback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseRecorderCloseNotify(t *testing.T) {
+	recorder := newResponseRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	// Go1.17的ReverseProxy会对gin的ResponseWriter调用CloseNotify
+	select {
+	case <-c.Writer.CloseNotify():
+		t.Fatal("unexpected close notify")
+	default:
+	}
+}
+
+func TestResponseRecorderReverseProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		w.Write([]byte("reply"))
+	}))
+	defer target.Close()
+	targetUrl, _ := url.Parse(target.URL + "/wxcallback")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recorder := newResponseRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequestWithContext(ctx, http.MethodPost, "/wxcallback/biz/wxappid",
+		strings.NewReader(`{"MsgType":"text"}`))
+
+	var record model.WxCallbackDeliveryRecord
+	newReverseProxy(targetUrl, &record).ServeHTTP(c.Writer, c.Request)
+	if record.ErrMsg != "" {
+		t.Fatalf("proxy err: %s", record.ErrMsg)
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "reply" || recorder.Header().Get("X-Test") != "1" {
+		t.Fatalf("unexpected response: %d %v %s", recorder.Code, recorder.Header(), recorder.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package wxcallback
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 代码审核结果推送驱动发布状态 审核通过且开启了自动发布时直接发布
+
+type weappAuditRecord struct {
+	Event  string     `json:"Event"`
+	Reason jsonString `json:"Reason"`
+}
+
+// weappAuditHandler 代码审核结果 审核延后时状态保持审核中 只记录原因
+// 重复或迟到的推送不满足状态变更规则 直接忽略
+func weappAuditHandler(appid string, body *[]byte) error {
+	var record weappAuditRecord
+	if err := binding.JSON.BindBody(*body, &record); err != nil {
+		log.Errorf("bind err %v", err)
+		return err
+	}
+	var err error
+	switch record.Event {
+	case model.WEAPPAUDIT_SUCCESS:
+		var state *model.WxReleaseState
+		state, err = dao.TransitReleaseState(appid, model.RELEASESTATE_AUDITPASS, model.RELEASESOURCE_EVENT, "", nil)
+		if err == nil && state.AutoRelease != 0 {
+			// 发布接口较慢 不阻塞给微信的回包
+			go autoRelease(appid)
+		}
+	case model.WEAPPAUDIT_FAIL:
+		_, err = dao.TransitReleaseState(appid, model.RELEASESTATE_AUDITFAIL, model.RELEASESOURCE_EVENT,
+			string(record.Reason), nil)
+	case model.WEAPPAUDIT_DELAY:
+		_, err = dao.TransitReleaseState(appid, model.RELEASESTATE_AUDITING, model.RELEASESOURCE_EVENT,
+			string(record.Reason), nil)
+	}
+	if errors.Is(err, dao.ErrReleaseTransition) {
+		return nil
+	}
+	return err
+}
+
+// autoRelease 发布审核通过的版本 失败时状态保持审核通过 可在管理端手动发布
+func autoRelease(appid string) {
+	if _, _, err := wx.PostWxJsonWithAuthToken(appid, "/wxa/release", "", gin.H{}); err != nil {
+		log.Errorf("auto release %s err %v", appid, err)
+		dao.TransitReleaseState(appid, model.RELEASESTATE_AUDITPASS, model.RELEASESOURCE_AUTO,
+			fmt.Sprintf("自动发布失败: %v", err), nil)
+		return
+	}
+	dao.TransitReleaseState(appid, model.RELEASESTATE_RELEASED, model.RELEASESOURCE_AUTO, "", nil)
+}
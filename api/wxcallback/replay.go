@@ -0,0 +1,120 @@
+package wxcallback
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 消息重放：把已记录的消息按当前的转发规则重新投递 请求会带上重放header 值为原消息记录的id
+
+// ReplayHeader 重放请求的header
+const ReplayHeader = "X-WxComponent-Replay"
+
+// 重放结果
+const (
+	REPLAYRESULT_SUCC   = "succ"
+	REPLAYRESULT_FAIL   = "fail"
+	REPLAYRESULT_QUEUED = "queued"
+	REPLAYRESULT_NORULE = "norule"
+)
+
+// ReplayResult 单条消息的重放结果
+type ReplayResult struct {
+	RecordID int64  `json:"recordId"`
+	RuleID   int32  `json:"ruleId"`
+	Result   string `json:"result"`
+	Targets  int    `json:"targets"`
+	Failed   int    `json:"failed"`
+	ErrMsg   string `json:"errMsg,omitempty"`
+}
+
+var replayMutex sync.Mutex
+var replayLastTime time.Time
+
+// waitReplayRate 所有重放请求共用一个速率限制
+func waitReplayRate() {
+	qps := config.WxCallbackConf.ReplayQps
+	if qps <= 0 {
+		return
+	}
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	if wait := time.Until(replayLastTime.Add(time.Second / time.Duration(qps))); wait > 0 {
+		time.Sleep(wait)
+	}
+	replayLastTime = time.Now()
+}
+
+// ReplayCallbackMsg 按当前规则重放一条消息 异步规则写入投递队列
+func ReplayCallbackMsg(recordId int64, appid string, infoType string, msgType string,
+	event string, body string) *ReplayResult {
+	waitReplayRate()
+	res := &ReplayResult{RecordID: recordId}
+	defer func() {
+		log.Infof("replay record %d rule %d: %s %s", recordId, res.RuleID, res.Result, res.ErrMsg)
+	}()
+	parsed := ParseCallbackMsg([]byte(body))
+	rule, err := dao.GetWxCallBackRuleWithCache(appid, infoType, msgType, event, parsed)
+	if err != nil {
+		res.Result, res.ErrMsg = REPLAYRESULT_FAIL, err.Error()
+		return res
+	}
+	if rule == nil {
+		res.Result = REPLAYRESULT_NORULE
+		return res
+	}
+	res.RuleID = rule.ID
+	if body, err = TransformCallbackMsg(rule, appid, body, parsed); err != nil {
+		res.Result, res.ErrMsg = REPLAYRESULT_FAIL, err.Error()
+		return res
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(ReplayHeader, strconv.FormatInt(recordId, 10))
+	if rule.Async != 0 && AsyncEnabled() {
+		if err = enqueueCallbackMsg(rule, appid, header, "", body); err != nil {
+			res.Result, res.ErrMsg = REPLAYRESULT_FAIL, err.Error()
+			return res
+		}
+		res.Result = REPLAYRESULT_QUEUED
+		return res
+	}
+
+	sinks, err := NewSinks(rule, appid)
+	if err != nil {
+		res.Result, res.ErrMsg = REPLAYRESULT_FAIL, err.Error()
+		return res
+	}
+	msg := &SinkMsg{Appid: appid, Header: header, Body: body, ReplayID: recordId}
+	var records []*model.WxCallbackDeliveryRecord
+	for i, sink := range sinks {
+		records = append(records, deliverToSink(rule, sink, i == 0, msg))
+	}
+
+	res.Result = REPLAYRESULT_SUCC
+	res.Targets = len(records)
+	for _, v := range records {
+		if v.Result != model.DELIVERYRESULT_SUCC {
+			res.Failed++
+			res.Result, res.ErrMsg = REPLAYRESULT_FAIL, v.ErrMsg
+		}
+	}
+	return res
+}
+
+// withReplayHeader webhook请求加上重放header
+func withReplayHeader(webhook *model.WebhookConfig, recordId int64) {
+	headers := make(map[string]string, len(webhook.Headers)+1)
+	for k, v := range webhook.Headers {
+		headers[k] = v
+	}
+	headers[ReplayHeader] = strconv.FormatInt(recordId, 10)
+	webhook.Headers = headers
+}
@@ -0,0 +1,76 @@
+package wxcallback
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 测试转发：构造与微信推送相同的请求 按指定规则走真实推送的转发流程 记录给微信的回包
+
+const ruleTestTimeout = 30 * time.Second
+
+// RuleTestResult 测试转发的结果 statusCode、header、body为真实推送时给微信的回包
+type RuleTestResult struct {
+	RuleID        int32       `json:"ruleId"`
+	Appid         string      `json:"appid"`
+	MatchedRuleID int32       `json:"matchedRuleId"` // 真实推送时命中的规则 与ruleId不同时该规则收不到这条消息
+	Payload       string      `json:"payload"`       // 测试消息
+	StatusCode    int         `json:"statusCode"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	Latency       int64       `json:"latency"` // 毫秒
+}
+
+// TestCallbackRule 用测试消息测试转发规则 规则未启用时也会转发 异步规则按同步转发以便查看回包
+// 授权事件没有appid 按第三方平台推送的路径转发
+func TestCallbackRule(rule *model.WxCallbackRule, appid string, payload string) (*RuleTestResult, error) {
+	msg := ParseCallbackMsg([]byte(payload))
+	if err := rule.CheckConditions(msg); err != nil {
+		return nil, fmt.Errorf("测试消息不满足规则的匹配条件, %v", err)
+	}
+	path := "/wxcallback/biz/" + appid
+	if rule.InfoType != "" {
+		appid, path = "", "/wxcallback/component"
+	}
+	res := &RuleTestResult{RuleID: rule.ID, Appid: appid, Payload: payload}
+	infoType, _ := msg["InfoType"].(string)
+	msgType, _ := msg["MsgType"].(string)
+	event, _ := msg["Event"].(string)
+	matched, err := dao.GetWxCallBackRuleWithCache(appid, infoType, msgType, event, msg)
+	if err != nil {
+		return nil, err
+	}
+	if matched != nil {
+		res.MatchedRuleID = matched.ID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ruleTestTimeout)
+	defer cancel()
+	recorder := newResponseRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	if c.Request, err = http.NewRequestWithContext(ctx, http.MethodPost, path,
+		strings.NewReader(payload)); err != nil {
+		return nil, err
+	}
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "appid", Value: appid}}
+
+	testRule := *rule
+	testRule.Async = 0
+	begin := time.Now()
+	if err = deliverCallbackMsg(&testRule, payload, msg, c); err != nil {
+		return nil, err
+	}
+	res.Latency = time.Since(begin).Milliseconds()
+	res.StatusCode = recorder.Code
+	res.Header = recorder.Header()
+	res.Body = recorder.Body.String()
+	return res, nil
+}
@@ -0,0 +1,117 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 转发目标：规则按type选择投递方式 配置在info中
+// 同步转发时http和webhook的回包返回给微信 其余方式投递后回复success
+
+// Sink 消息的投递目标
+type Sink interface {
+	// Target 目标的描述 记录在转发记录中
+	Target() string
+	// Deliver 投递消息 http类目标把状态码写入record 失败时写入ErrMsg
+	Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord)
+}
+
+// SinkMsg 待投递的消息
+type SinkMsg struct {
+	Appid    string
+	Header   http.Header
+	RawQuery string
+	Body     string
+	ReplayID int64 // 重放时为原消息记录的id
+}
+
+type sinkFactory func(rule *model.WxCallbackRule, appid string) ([]Sink, error)
+
+var sinkFactories = map[int]sinkFactory{
+	model.PROXYTYPE_HTTP:    newHttpSinks,
+	model.PROXYTYPE_WEBHOOK: newWebhookSinks,
+	model.PROXYTYPE_FILE:    newFileSinks,
+	model.PROXYTYPE_COMMAND: newCommandSinks,
+	model.PROXYTYPE_MQ:      newMQSinks,
+}
+
+// NewSinks 按规则的转发类型创建投递目标 第一个为主目标
+func NewSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	factory, ok := sinkFactories[rule.Type]
+	if !ok {
+		return nil, errors.New("转发类型错误")
+	}
+	sinks, err := factory(rule, appid)
+	if err != nil {
+		log.Errorf("new sinks err, %v", err)
+		return nil, err
+	}
+	return sinks, nil
+}
+
+// deliverToSink 投递到一个目标并保存转发记录
+func deliverToSink(rule *model.WxCallbackRule, sink Sink, primary bool,
+	msg *SinkMsg) *model.WxCallbackDeliveryRecord {
+	record := newDeliveryRecord(rule, msg.Appid, sink.Target(), primary)
+	record.ReplayID = msg.ReplayID
+	begin := time.Now()
+	sink.Deliver(msg, record)
+	finishDeliveryRecord(record, begin)
+	return record
+}
+
+type httpSink struct {
+	url *url.URL
+}
+
+func newHttpSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	urls, err := genHttpTargetUrls(rule, appid)
+	if err != nil {
+		return nil, err
+	}
+	sinks := make([]Sink, len(urls))
+	for i, v := range urls {
+		sinks[i] = &httpSink{url: v}
+	}
+	return sinks, nil
+}
+
+func (s *httpSink) Target() string {
+	return s.url.String()
+}
+
+func (s *httpSink) Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord) {
+	target := *s.url
+	deliverHttp(record, &target, msg.Header.Clone(), msg.RawQuery, msg.Body)
+}
+
+type webhookSink struct {
+	webhook model.WebhookConfig
+	appid   string
+}
+
+func newWebhookSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	var webhook model.WebhookConfig
+	if err := json.Unmarshal([]byte(rule.Info), &webhook); err != nil {
+		return nil, err
+	}
+	return []Sink{&webhookSink{webhook: webhook, appid: appid}}, nil
+}
+
+func (s *webhookSink) Target() string {
+	return GenWebhookUrl(&s.webhook, s.appid)
+}
+
+func (s *webhookSink) Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord) {
+	webhook := s.webhook
+	if msg.ReplayID != 0 {
+		withReplayHeader(&webhook, msg.ReplayID)
+	}
+	deliverWebhook(record, &webhook, msg.Appid, msg.Body)
+}
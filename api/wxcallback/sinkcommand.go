@@ -0,0 +1,110 @@
+package wxcallback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 命令转发：启动本地命令 消息内容写入标准输入 appid等信息通过环境变量传入 退出码非0时投递失败
+
+const defaultCommandTimeout = 5000
+const commandStderrLimit = 512
+
+type commandSink struct {
+	config model.CommandSinkConfig
+	ruleId int32
+}
+
+func newCommandSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	var config model.CommandSinkConfig
+	if err := json.Unmarshal([]byte(rule.Info), &config); err != nil {
+		return nil, err
+	}
+	if err := config.Check(); err != nil {
+		return nil, err
+	}
+	if err := CheckSinkCommand(config.Command); err != nil {
+		return nil, err
+	}
+	return []Sink{&commandSink{config: config, ruleId: rule.ID}}, nil
+}
+
+// CheckSinkCommand 命令需在server.conf的SinkCommands中 避免通过管理端执行任意命令
+func CheckSinkCommand(command string) error {
+	for _, v := range config.WxCallbackConf.SinkCommands {
+		if v = strings.TrimSpace(v); v != "" && filepath.Clean(v) == filepath.Clean(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("命令%s不在允许执行的列表中 需配置SinkCommands", command)
+}
+
+func (s *commandSink) Target() string {
+	return "command://" + strings.Join(append([]string{s.config.Command}, s.config.Args...), " ")
+}
+
+func (s *commandSink) Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord) {
+	timeout := s.config.Timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	// 标准输入输出使用临时文件而不是管道 命令启动的子进程在超时后仍持有管道时 Run不会等待子进程退出
+	stdin, err := newCommandTempFile(msg.Body)
+	if err != nil {
+		record.ErrMsg = err.Error()
+		return
+	}
+	defer removeCommandTempFile(stdin)
+	stderr, err := newCommandTempFile("")
+	if err != nil {
+		record.ErrMsg = err.Error()
+		return
+	}
+	defer removeCommandTempFile(stderr)
+	cmd := exec.CommandContext(ctx, s.config.Command, s.config.Args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(),
+		"WXCALLBACK_APPID="+msg.Appid,
+		"WXCALLBACK_RULEID="+strconv.Itoa(int(s.ruleId)),
+		"WXCALLBACK_REPLAYID="+strconv.FormatInt(msg.ReplayID, 10))
+	if err := cmd.Run(); err != nil {
+		output := make([]byte, commandStderrLimit)
+		n, _ := stderr.ReadAt(output, 0)
+		record.ErrMsg = strings.TrimSpace(fmt.Sprintf("%v %s", err, output[:n]))
+	}
+}
+
+func newCommandTempFile(content string) (*os.File, error) {
+	f, err := ioutil.TempFile("", "wxcallback-command-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.WriteString(content); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeCommandTempFile(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+func removeCommandTempFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
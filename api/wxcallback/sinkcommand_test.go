@@ -0,0 +1,101 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+const testShell = "/bin/sh"
+
+func newTestCommandSink(t *testing.T, timeout int, script string) Sink {
+	if _, err := os.Stat(testShell); err != nil {
+		t.Skipf("%s not found", testShell)
+	}
+	old := config.WxCallbackConf.SinkCommands
+	config.WxCallbackConf.SinkCommands = []string{testShell}
+	t.Cleanup(func() { config.WxCallbackConf.SinkCommands = old })
+
+	info, _ := json.Marshal(model.CommandSinkConfig{Command: testShell, Args: []string{"-c", script},
+		Timeout: timeout})
+	sinks, err := NewSinks(&model.WxCallbackRule{ID: 7, Type: model.PROXYTYPE_COMMAND, Info: string(info)}, "wxappid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sinks[0]
+}
+
+func TestCommandSinkStdinAndEnv(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out")
+	sink := newTestCommandSink(t, 0,
+		`cat > "$0.body" && echo "$WXCALLBACK_APPID $WXCALLBACK_RULEID $WXCALLBACK_REPLAYID" > "$0.env"`)
+	// sh -c的第一个额外参数为$0 用于传入输出路径
+	sink.(*commandSink).config.Args = append(sink.(*commandSink).config.Args, output)
+
+	var record model.WxCallbackDeliveryRecord
+	sink.Deliver(&SinkMsg{Appid: "wxappid", Body: `{"MsgType":"text"}`, ReplayID: 3}, &record)
+	if record.ErrMsg != "" {
+		t.Fatalf("deliver err: %s", record.ErrMsg)
+	}
+	if body, _ := os.ReadFile(output + ".body"); string(body) != `{"MsgType":"text"}` {
+		t.Errorf("stdin = %q", body)
+	}
+	if env, _ := os.ReadFile(output + ".env"); strings.TrimSpace(string(env)) != "wxappid 7 3" {
+		t.Errorf("env = %q", env)
+	}
+}
+
+func TestCommandSinkExitCodeAndStderr(t *testing.T) {
+	sink := newTestCommandSink(t, 0, "echo something went wrong >&2; exit 3")
+	var record model.WxCallbackDeliveryRecord
+	sink.Deliver(&SinkMsg{Appid: "wxappid", Body: "{}"}, &record)
+	if !strings.Contains(record.ErrMsg, "exit status 3") {
+		t.Errorf("ErrMsg %q should contain exit status", record.ErrMsg)
+	}
+	if !strings.Contains(record.ErrMsg, "something went wrong") {
+		t.Errorf("ErrMsg %q should contain stderr", record.ErrMsg)
+	}
+}
+
+func TestCommandSinkStderrLimit(t *testing.T) {
+	sink := newTestCommandSink(t, 0, "head -c 4096 /dev/zero | tr '\\0' y >&2; exit 1")
+	var record model.WxCallbackDeliveryRecord
+	sink.Deliver(&SinkMsg{Appid: "wxappid", Body: "{}"}, &record)
+	limited := strings.Repeat("y", commandStderrLimit)
+	if !strings.HasSuffix(record.ErrMsg, " "+limited) {
+		t.Errorf("ErrMsg should end with %d bytes of stderr, got %d bytes", commandStderrLimit, len(record.ErrMsg))
+	}
+}
+
+func TestCommandSinkTimeout(t *testing.T) {
+	sink := newTestCommandSink(t, 100, "sleep 5")
+	var record model.WxCallbackDeliveryRecord
+	begin := time.Now()
+	sink.Deliver(&SinkMsg{Appid: "wxappid", Body: "{}"}, &record)
+	if elapsed := time.Since(begin); elapsed > 3*time.Second {
+		t.Errorf("command not killed after timeout, took %v", elapsed)
+	}
+	if record.ErrMsg == "" {
+		t.Error("timeout should fail the delivery")
+	}
+}
+
+func TestCommandSinkNotAllowed(t *testing.T) {
+	old := config.WxCallbackConf.SinkCommands
+	config.WxCallbackConf.SinkCommands = []string{"/usr/local/bin/wxhook"}
+	defer func() { config.WxCallbackConf.SinkCommands = old }()
+
+	if err := CheckSinkCommand("/usr/local/bin/wxhook"); err != nil {
+		t.Errorf("allowed command rejected: %v", err)
+	}
+	info, _ := json.Marshal(model.CommandSinkConfig{Command: testShell, Args: []string{"-c", "true"}})
+	if _, err := NewSinks(&model.WxCallbackRule{Type: model.PROXYTYPE_COMMAND, Info: string(info)}, "wxappid"); err == nil {
+		t.Error("command outside SinkCommands should be rejected")
+	}
+}
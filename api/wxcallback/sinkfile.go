@@ -0,0 +1,141 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 文件转发：每条消息追加一行json(ndjson) 文件超过maxSize后重命名为 文件名.时间 并清理多余的轮转文件
+
+var fileSinkMutex sync.Mutex
+
+type fileSinkLine struct {
+	ReceiveTime int64       `json:"receiveTime"`
+	Appid       string      `json:"appid"`
+	RuleID      int32       `json:"ruleId"`
+	ReplayID    int64       `json:"replayId,omitempty"`
+	Body        interface{} `json:"body"`
+}
+
+type fileSink struct {
+	config model.FileSinkConfig
+	ruleId int32
+	path   string
+}
+
+func newFileSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	var config model.FileSinkConfig
+	if err := json.Unmarshal([]byte(rule.Info), &config); err != nil {
+		return nil, err
+	}
+	if err := config.Check(); err != nil {
+		return nil, err
+	}
+	// appid来自推送地址 替换后再检查一次
+	path := strings.Replace(config.Path, "$APPID$", appid, -1)
+	if err := CheckFileSinkPath(path); err != nil {
+		return nil, err
+	}
+	return []Sink{&fileSink{
+		config: config,
+		ruleId: rule.ID,
+		path:   path,
+	}}, nil
+}
+
+// CheckFileSinkPath 文件需在server.conf的SinkFileDirs配置的目录下 避免通过管理端写入任意文件
+func CheckFileSinkPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("文件路径%s需为绝对路径", path)
+	}
+	path = filepath.Clean(path)
+	for _, dir := range config.WxCallbackConf.SinkFileDirs {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(filepath.Clean(dir), path); err == nil && rel != "." &&
+			rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("文件路径%s不在允许写入的目录中 需配置SinkFileDirs", path)
+}
+
+func (s *fileSink) Target() string {
+	return "file://" + s.path
+}
+
+func (s *fileSink) Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord) {
+	line := fileSinkLine{
+		ReceiveTime: time.Now().Unix(),
+		Appid:       msg.Appid,
+		RuleID:      s.ruleId,
+		ReplayID:    msg.ReplayID,
+		Body:        msg.Body,
+	}
+	if json.Valid([]byte(msg.Body)) {
+		line.Body = json.RawMessage(msg.Body)
+	}
+	value, err := json.Marshal(line)
+	if err != nil {
+		record.ErrMsg = err.Error()
+		return
+	}
+	if err = s.write(append(value, '\n')); err != nil {
+		record.ErrMsg = err.Error()
+	}
+}
+
+func (s *fileSink) write(value []byte) error {
+	fileSinkMutex.Lock()
+	defer fileSinkMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if s.config.MaxSize > 0 {
+		if info, err := os.Stat(s.path); err == nil &&
+			info.Size()+int64(len(value)) > int64(s.config.MaxSize)<<20 {
+			if err = s.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate 当前文件重命名为带时间的备份 只保留最新的maxBackups个备份
+func (s *fileSink) rotate() error {
+	backup := s.path + "." + time.Now().Format("20060102150405.000000")
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	if s.config.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-s.config.MaxBackups; i++ {
+		os.Remove(backups[i])
+	}
+	return nil
+}
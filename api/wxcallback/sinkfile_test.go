@@ -0,0 +1,138 @@
+package wxcallback
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+func newTestFileRule(sinkConfig model.FileSinkConfig) *model.WxCallbackRule {
+	info, _ := json.Marshal(sinkConfig)
+	return &model.WxCallbackRule{ID: 1, Type: model.PROXYTYPE_FILE, Info: string(info)}
+}
+
+func setSinkFileDirs(t *testing.T, dirs ...string) {
+	old := config.WxCallbackConf.SinkFileDirs
+	config.WxCallbackConf.SinkFileDirs = dirs
+	t.Cleanup(func() { config.WxCallbackConf.SinkFileDirs = old })
+}
+
+func TestFileSinkAppendsLines(t *testing.T) {
+	dir := t.TempDir()
+	setSinkFileDirs(t, dir)
+	rule := newTestFileRule(model.FileSinkConfig{Path: filepath.Join(dir, "$APPID$", "msg.log")})
+	sinks, err := NewSinks(rule, "wxappid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"MsgType":"text"}`, "not json"} {
+		var record model.WxCallbackDeliveryRecord
+		sinks[0].Deliver(&SinkMsg{Appid: "wxappid", Body: body}, &record)
+		if record.ErrMsg != "" {
+			t.Fatalf("deliver err: %s", record.ErrMsg)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "wxappid", "msg.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []fileSinkLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line fileSinkLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if body, ok := lines[0].Body.(map[string]interface{}); !ok || body["MsgType"] != "text" {
+		t.Errorf("json body not embedded: %#v", lines[0].Body)
+	}
+	if lines[1].Body != "not json" || lines[1].Appid != "wxappid" || lines[1].RuleID != 1 {
+		t.Errorf("unexpected line: %#v", lines[1])
+	}
+}
+
+func TestFileSinkRotateAndPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	setSinkFileDirs(t, dir)
+	path := filepath.Join(dir, "msg.log")
+	rule := newTestFileRule(model.FileSinkConfig{Path: path, MaxSize: 1, MaxBackups: 2})
+	sinks, err := NewSinks(rule, "wxappid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每条约400KB 1MB的文件写满两条后轮转
+	body := strings.Repeat("a", 400<<10)
+	for i := 0; i < 9; i++ {
+		var record model.WxCallbackDeliveryRecord
+		sinks[0].Deliver(&SinkMsg{Appid: "wxappid", Body: body}, &record)
+		if record.ErrMsg != "" {
+			t.Fatalf("deliver err: %s", record.ErrMsg)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1<<20 {
+		t.Errorf("current file size %d exceeds max size", info.Size())
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2: %v", len(backups), backups)
+	}
+	for _, v := range backups {
+		if info, err := os.Stat(v); err != nil || info.Size() > 1<<20 {
+			t.Errorf("unexpected backup %s: %v", v, err)
+		}
+	}
+}
+
+func TestCheckFileSinkPath(t *testing.T) {
+	dir := t.TempDir()
+	setSinkFileDirs(t, dir)
+	cases := []struct {
+		path string
+		ok   bool
+	}{
+		{filepath.Join(dir, "msg.log"), true},
+		{filepath.Join(dir, "sub", "msg.log"), true},
+		{dir, false},
+		{filepath.Join(dir, "..", "msg.log"), false},
+		{dir + "-other/msg.log", false},
+		{"relative/msg.log", false},
+		{"/etc/passwd", false},
+	}
+	for _, v := range cases {
+		if err := CheckFileSinkPath(v.path); (err == nil) != v.ok {
+			t.Errorf("CheckFileSinkPath(%s) = %v, want ok %v", v.path, err, v.ok)
+		}
+	}
+
+	setSinkFileDirs(t)
+	if err := CheckFileSinkPath(filepath.Join(dir, "msg.log")); err == nil {
+		t.Error("file sink should be disabled without SinkFileDirs")
+	}
+}
+
+func TestFileSinkRejectsAppidTraversal(t *testing.T) {
+	dir := t.TempDir()
+	setSinkFileDirs(t, dir)
+	rule := newTestFileRule(model.FileSinkConfig{Path: filepath.Join(dir, "$APPID$.log")})
+	if _, err := NewSinks(rule, "../../tmp/evil"); err == nil {
+		t.Error("path outside SinkFileDirs should be rejected")
+	}
+}
@@ -0,0 +1,154 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 消息队列转发：按driver选择消息队列的实现 消息的key为appid
+// 内置的local为进程内的队列 用于测试和本地调试 其他消息队列通过RegisterMQDriver注册
+
+// MQProducer 消息队列的生产者
+type MQProducer interface {
+	Publish(topic string, key string, body []byte) error
+}
+
+// MQDriver 按配置创建生产者 相同配置的生产者会被复用
+type MQDriver func(config *model.MQSinkConfig) (MQProducer, error)
+
+const MQDRIVER_LOCAL = "local"
+
+var mqDriverMutex sync.RWMutex
+var mqDrivers = map[string]MQDriver{
+	MQDRIVER_LOCAL: func(config *model.MQSinkConfig) (MQProducer, error) {
+		return GetLocalMQ(), nil
+	},
+}
+var mqProducers sync.Map
+
+// RegisterMQDriver 注册消息队列的实现 需在服务启动时调用
+func RegisterMQDriver(name string, driver MQDriver) {
+	mqDriverMutex.Lock()
+	defer mqDriverMutex.Unlock()
+	mqDrivers[name] = driver
+}
+
+// HasMQDriver 是否已注册该消息队列的实现
+func HasMQDriver(name string) bool {
+	mqDriverMutex.RLock()
+	defer mqDriverMutex.RUnlock()
+	_, ok := mqDrivers[name]
+	return ok
+}
+
+func getMQProducer(config *model.MQSinkConfig) (MQProducer, error) {
+	keys := make([]string, 0, len(config.Options))
+	for k := range config.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cacheKey := config.Driver + "|" + config.Addr
+	for _, k := range keys {
+		cacheKey += "|" + k + "=" + config.Options[k]
+	}
+	if producer, ok := mqProducers.Load(cacheKey); ok {
+		return producer.(MQProducer), nil
+	}
+	mqDriverMutex.RLock()
+	driver, ok := mqDrivers[config.Driver]
+	mqDriverMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("消息队列%s未注册", config.Driver)
+	}
+	producer, err := driver(config)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := mqProducers.LoadOrStore(cacheKey, producer)
+	return actual.(MQProducer), nil
+}
+
+type mqSink struct {
+	config model.MQSinkConfig
+	topic  string
+}
+
+func newMQSinks(rule *model.WxCallbackRule, appid string) ([]Sink, error) {
+	var config model.MQSinkConfig
+	if err := json.Unmarshal([]byte(rule.Info), &config); err != nil {
+		return nil, err
+	}
+	if err := config.Check(); err != nil {
+		return nil, err
+	}
+	return []Sink{&mqSink{config: config, topic: strings.Replace(config.Topic, "$APPID$", appid, -1)}}, nil
+}
+
+func (s *mqSink) Target() string {
+	return fmt.Sprintf("%s://%s/%s", s.config.Driver, s.config.Addr, s.topic)
+}
+
+func (s *mqSink) Deliver(msg *SinkMsg, record *model.WxCallbackDeliveryRecord) {
+	producer, err := getMQProducer(&s.config)
+	if err != nil {
+		record.ErrMsg = err.Error()
+		return
+	}
+	if err = producer.Publish(s.topic, msg.Appid, []byte(msg.Body)); err != nil {
+		record.ErrMsg = err.Error()
+	}
+}
+
+const localMQCapacity = 1000
+
+// LocalMQMsg 进程内队列中的消息
+type LocalMQMsg struct {
+	Key         string    `json:"key"`
+	Body        string    `json:"body"`
+	PublishTime time.Time `json:"publishTime"`
+}
+
+// LocalMQ 进程内的消息队列 每个topic保留最新的localMQCapacity条消息
+type LocalMQ struct {
+	mutex  sync.Mutex
+	topics map[string][]LocalMQMsg
+}
+
+var localMQ = &LocalMQ{topics: make(map[string][]LocalMQMsg)}
+
+// GetLocalMQ 获取进程内的消息队列
+func GetLocalMQ() *LocalMQ {
+	return localMQ
+}
+
+// Publish 写入消息 超过容量时丢弃最早的消息
+func (q *LocalMQ) Publish(topic string, key string, body []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	msgs := append(q.topics[topic], LocalMQMsg{Key: key, Body: string(body), PublishTime: time.Now()})
+	if len(msgs) > localMQCapacity {
+		msgs = msgs[len(msgs)-localMQCapacity:]
+	}
+	q.topics[topic] = msgs
+	return nil
+}
+
+// Consume 按写入顺序取出最多max条消息
+func (q *LocalMQ) Consume(topic string, max int) []LocalMQMsg {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	msgs := q.topics[topic]
+	if max <= 0 || max > len(msgs) {
+		max = len(msgs)
+	}
+	result := make([]LocalMQMsg, max)
+	copy(result, msgs[:max])
+	q.topics[topic] = msgs[max:]
+	return result
+}
@@ -0,0 +1,86 @@
+package wxcallback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+func newTestMQSink(t *testing.T, sinkConfig model.MQSinkConfig, appid string) Sink {
+	info, _ := json.Marshal(sinkConfig)
+	sinks, err := NewSinks(&model.WxCallbackRule{Type: model.PROXYTYPE_MQ, Info: string(info)}, appid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sinks[0]
+}
+
+func TestMQSinkPublishToLocalMQ(t *testing.T) {
+	sink := newTestMQSink(t, model.MQSinkConfig{Driver: MQDRIVER_LOCAL, Topic: "test_publish_$APPID$"}, "wxappid")
+	if target := sink.Target(); target != "local:///test_publish_wxappid" {
+		t.Errorf("Target() = %s", target)
+	}
+	for i := 0; i < 3; i++ {
+		var record model.WxCallbackDeliveryRecord
+		sink.Deliver(&SinkMsg{Appid: "wxappid", Body: fmt.Sprintf(`{"seq":%d}`, i)}, &record)
+		if record.ErrMsg != "" {
+			t.Fatalf("deliver err: %s", record.ErrMsg)
+		}
+	}
+
+	msgs := GetLocalMQ().Consume("test_publish_wxappid", 2)
+	if len(msgs) != 2 || msgs[0].Body != `{"seq":0}` || msgs[1].Body != `{"seq":1}` {
+		t.Fatalf("unexpected msgs: %+v", msgs)
+	}
+	if msgs[0].Key != "wxappid" {
+		t.Errorf("key = %s, want appid", msgs[0].Key)
+	}
+	if msgs = GetLocalMQ().Consume("test_publish_wxappid", 0); len(msgs) != 1 || msgs[0].Body != `{"seq":2}` {
+		t.Fatalf("unexpected remaining msgs: %+v", msgs)
+	}
+	if msgs = GetLocalMQ().Consume("test_publish_wxappid", 0); len(msgs) != 0 {
+		t.Errorf("topic should be empty, got %d", len(msgs))
+	}
+}
+
+func TestLocalMQCapacity(t *testing.T) {
+	q := &LocalMQ{topics: make(map[string][]LocalMQMsg)}
+	for i := 0; i < localMQCapacity+10; i++ {
+		q.Publish("topic", "key", []byte(fmt.Sprint(i)))
+	}
+	msgs := q.Consume("topic", 0)
+	if len(msgs) != localMQCapacity || msgs[0].Body != "10" {
+		t.Errorf("got %d msgs starting at %s, want %d starting at 10", len(msgs), msgs[0].Body, localMQCapacity)
+	}
+}
+
+type failingProducer struct{}
+
+func (failingProducer) Publish(topic string, key string, body []byte) error {
+	return errors.New("broker unavailable")
+}
+
+func TestMQSinkRegisteredDriver(t *testing.T) {
+	RegisterMQDriver("test_failing", func(config *model.MQSinkConfig) (MQProducer, error) {
+		return failingProducer{}, nil
+	})
+	if !HasMQDriver("test_failing") || HasMQDriver("test_missing") {
+		t.Fatal("HasMQDriver mismatch")
+	}
+	sink := newTestMQSink(t, model.MQSinkConfig{Driver: "test_failing", Addr: "127.0.0.1:9092", Topic: "t"}, "wxappid")
+	var record model.WxCallbackDeliveryRecord
+	sink.Deliver(&SinkMsg{Appid: "wxappid", Body: "{}"}, &record)
+	if record.ErrMsg != "broker unavailable" {
+		t.Errorf("ErrMsg = %q", record.ErrMsg)
+	}
+
+	missing := newTestMQSink(t, model.MQSinkConfig{Driver: "test_missing", Topic: "t"}, "wxappid")
+	record = model.WxCallbackDeliveryRecord{}
+	missing.Deliver(&SinkMsg{Appid: "wxappid", Body: "{}"}, &record)
+	if record.ErrMsg == "" {
+		t.Error("unregistered driver should fail the delivery")
+	}
+}
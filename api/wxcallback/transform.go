@@ -0,0 +1,58 @@
+package wxcallback
+
+import (
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 消息改写：规则配置了transform时 先改写消息内容再投递到各转发目标
+
+// TransformCallbackMsg 按规则改写消息内容 规则未配置改写时返回原内容
+func TransformCallbackMsg(rule *model.WxCallbackRule, appid string, body string,
+	msg map[string]interface{}) (string, error) {
+	transform, err := rule.GetTransform()
+	if err != nil {
+		log.Errorf("rule %d transform err, %v", rule.ID, err)
+		return "", err
+	}
+	if transform == nil {
+		return body, nil
+	}
+	result, err := RenderTransform(transform, rule.ID, appid, body, msg)
+	if err != nil {
+		log.Errorf("rule %d render transform err, %v", rule.ID, err)
+		return "", err
+	}
+	return result, nil
+}
+
+// RenderTransform 用指定的改写配置渲染消息 transform需已Compile
+// 授权事件没有appid 按消息中的AuthorizerAppid查询授权账号
+func RenderTransform(transform *model.RuleTransform, ruleId int32, appid string, body string,
+	msg map[string]interface{}) (string, error) {
+	if msg == nil {
+		msg = ParseCallbackMsg([]byte(body))
+	}
+	authorizerAppid := appid
+	if authorizerAppid == "" {
+		authorizerAppid, _ = msg["AuthorizerAppid"].(string)
+	}
+	var authorizer *model.Authorizer
+	if authorizerAppid != "" {
+		var err error
+		if authorizer, err = dao.GetAuthorizerRecordWithCache(authorizerAppid); err != nil {
+			return "", err
+		}
+	}
+	return transform.Render(&model.TransformData{
+		Appid:      appid,
+		RuleID:     ruleId,
+		Now:        time.Now().Unix(),
+		Raw:        body,
+		Msg:        msg,
+		Authorizer: model.GenTransformAuthorizer(authorizer),
+	})
+}
@@ -0,0 +1,105 @@
+package wxcallback
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/gin-gonic/gin"
+)
+
+// webhook签名相关的header 签名为hmac_sha256(secret, timestamp + "." + body)
+const (
+	WebhookTimestampHeader = "X-WxComponent-Timestamp"
+	WebhookSignatureHeader = "X-WxComponent-Signature"
+)
+
+const defaultWebhookTimeout = 5000
+
+// WebhookSignature 计算webhook签名
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenWebhookUrl 替换url中的$APPID$
+func GenWebhookUrl(webhook *model.WebhookConfig, appid string) string {
+	return strings.Replace(webhook.Url, "$APPID$", appid, -1)
+}
+
+// PostWebhook 向外部webhook投递消息
+func PostWebhook(webhook *model.WebhookConfig, appid string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, GenWebhookUrl(webhook, appid), bytes.NewBuffer(body))
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	for k, v := range webhook.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WxComponent/"+config.CommConf.Version)
+	if webhook.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(webhook.Secret, timestamp, body))
+	}
+
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Millisecond}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+	return resp, result, nil
+}
+
+// deliverWebhook 投递消息到webhook 结果写入record
+func deliverWebhook(record *model.WxCallbackDeliveryRecord, webhook *model.WebhookConfig,
+	appid string, body string) (*http.Response, []byte) {
+	resp, result, err := PostWebhook(webhook, appid, []byte(body))
+	if err != nil {
+		log.Errorf("webhook error: %v", err)
+		record.ErrMsg = err.Error()
+	}
+	if resp != nil {
+		record.StatusCode = resp.StatusCode
+	}
+	return resp, result
+}
+
+// proxyWebhook 转发到外部webhook 回包返回给微信
+func proxyWebhook(rule *model.WxCallbackRule, webhook *model.WebhookConfig, body string, c *gin.Context) {
+	appid := c.Param("appid")
+	record := newDeliveryRecord(rule, appid, GenWebhookUrl(webhook, appid), true)
+	begin := time.Now()
+	resp, result := deliverWebhook(record, webhook, appid, body)
+	if resp == nil {
+		c.JSON(http.StatusOK, errno.ErrSystemError.WithData(record.ErrMsg))
+	} else {
+		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), result)
+	}
+	markDeliveryFinished(c, record)
+	go finishDeliveryRecord(record, begin)
+}
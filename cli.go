@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/admin"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/proxy"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+)
+
+// 命令行模式：导入导出转发规则和代理配置 执行完成后退出 不启动服务
+// 导出: ./main -export-config rules.yaml (文件为-时输出到标准输出)
+// 导入: ./main -import-config rules.yaml -strategy skip|overwrite|fail [-dry-run]
+
+var exportConfigFile = flag.String("export-config", "", "导出转发规则和代理配置到yaml文件")
+var importConfigFile = flag.String("import-config", "", "从yaml文件导入转发规则和代理配置")
+var importStrategy = flag.String("strategy", admin.IMPORTSTRATEGY_FAIL, "导入时与已有配置冲突的处理方式 skip、overwrite或fail")
+var importDryRun = flag.Bool("dry-run", false, "只输出导入的差异 不写入")
+
+// cliOperator 命令行导入时记录在规则变更历史中的操作人
+const cliOperator = "cli"
+
+// runConfigCli 有导入导出参数时执行并返回true
+func runConfigCli() bool {
+	if *exportConfigFile == "" && *importConfigFile == "" {
+		return false
+	}
+	if err := db.Init(); err != nil {
+		exitWithError(err)
+	}
+	if err := proxy.Init(); err != nil {
+		exitWithError(err)
+	}
+	if *exportConfigFile != "" {
+		value, err := admin.ExportConfig()
+		if err != nil {
+			exitWithError(err)
+		}
+		if *exportConfigFile == "-" {
+			os.Stdout.Write(value)
+		} else if err = ioutil.WriteFile(*exportConfigFile, value, 0644); err != nil {
+			exitWithError(err)
+		}
+		return true
+	}
+
+	data, err := ioutil.ReadFile(*importConfigFile)
+	if err != nil {
+		exitWithError(err)
+	}
+	res, err := admin.ImportConfig(data, *importStrategy, *importDryRun, cliOperator)
+	if res != nil {
+		value, _ := json.MarshalIndent(res, "", "  ")
+		fmt.Println(string(value))
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	return true
+}
+
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -78,3 +78,9 @@ export  const normalAccountStatus: Record<number, string> = {
     18: '已告警',
     19: '已冻结',
 }
+
+export const authorizerHealth: Record<number, string> = {
+    0: '正常',
+    1: '刷新token失败',
+    2: '需重新授权',
+}
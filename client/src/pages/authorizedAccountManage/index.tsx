@@ -17,7 +17,7 @@ import {
     tokenColumn,
     tabs,
     serviceStatus,
-    accountStatus, registerType, normalAccountStatus
+    accountStatus, registerType, normalAccountStatus, authorizerHealth
 } from './enum'
 import {routes} from "../../config/route";
 
@@ -80,6 +80,13 @@ export default function AuthorizedAccountManage() {
             title: '帐号状态',
             render: ({ row }) => normalAccountStatus[row.accountStatus]
         },
+        {
+            align: 'center',
+            minWidth: 100,
+            colKey: 'health',
+            title: '授权状态',
+            render: ({ row }) => row.healthMsg ? `${authorizerHealth[row.health]}(${row.healthMsg})` : authorizerHealth[row.health]
+        },
         {
             align: 'center',
             minWidth: 100,
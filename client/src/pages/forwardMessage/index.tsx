@@ -186,7 +186,12 @@ export default function ForwardMessage() {
     const [testResp, setTestResp] = useState<undefined | {
         code: number,
         errorMsg: string
-        data: string
+        data: string | {
+            statusCode: number
+            latency: number
+            header: Record<string, string[]>
+            body: string
+        }
     }>(undefined)
 
     useEffect(() => {
@@ -322,11 +327,7 @@ export default function ForwardMessage() {
             MessagePlugin.error('系统错误，请稍后重试')
             return
         }
-        setTestResp(resp as {
-            code: number
-            errorMsg: string
-            data: string
-        })
+        setTestResp(resp as typeof testResp)
     }
 
     return (
@@ -514,7 +515,17 @@ export default function ForwardMessage() {
                     &&
                     <div>
                         <p>接口返回值：</p>
-                        <p style={{ whiteSpace: 'pre-wrap', margin: 0, padding: '20px', backgroundColor: 'rgba(0,0,0,0.1)' }}>{testResp.data}</p>
+                        {
+                            typeof testResp.data === 'string'
+                                ?
+                                <p style={{ whiteSpace: 'pre-wrap', margin: 0, padding: '20px', backgroundColor: 'rgba(0,0,0,0.1)' }}>{testResp.data}</p>
+                                :
+                                <div>
+                                    <p>状态码：{testResp.data.statusCode}，耗时：{testResp.data.latency}ms</p>
+                                    <p style={{ whiteSpace: 'pre-wrap', margin: 0, padding: '20px', backgroundColor: 'rgba(0,0,0,0.1)' }}>{Object.entries(testResp.data.header || {}).map(([key, value]) => `${key}: ${value.join(', ')}`).join('\n')}</p>
+                                    <p style={{ whiteSpace: 'pre-wrap', margin: 0, padding: '20px', backgroundColor: 'rgba(0,0,0,0.1)' }}>{testResp.data.body}</p>
+                                </div>
+                        }
                     </div>
                 }
             </Drawer>
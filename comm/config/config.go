@@ -28,9 +28,101 @@ type Comm struct {
 	Version string
 }
 
+// WxCallback 消息推送配置结构体
+type WxCallback struct {
+	Token             string   // 消息校验Token
+	EncodingAESKey    string   // 消息加解密Key
+	TrustSourceHeader bool     // 是否信任云托管注入的x-wx-source头
+	AsyncWorkers      int      // 异步转发的并发数
+	AsyncMaxAttempts  int      // 异步转发的最大投递次数 超过后转为死信
+	AsyncRetryBackoff int      // 异步转发首次重试的间隔 单位秒 之后按指数退避
+	DedupWindow       int      // 微信重试推送的去重窗口 单位秒 为0时不去重
+	ReplayQps         int      // 消息重放的速率限制 每秒最多重放的消息数
+	ReplyDeadline     int      // 同步转发等待回包的默认期限 单位毫秒 超过后先回复微信success 为0时不限制
+	SinkCommands      []string // 命令转发允许执行的命令 逗号分隔 为空时不允许命令转发
+	SinkFileDirs      []string // 文件转发允许写入的目录 逗号分隔 为空时不允许文件转发
+}
+
+// Retention 消息记录的保留策略配置结构体 天数和行数为0时不限制
+type Retention struct {
+	PurgeInterval      int   // 清理间隔 单位分钟 为0时不清理
+	PurgeBatchSize     int   // 每批删除的行数
+	PurgeBatchPause    int   // 每批之间的停顿 单位毫秒 避免长时间锁表
+	ComponentMaxDays   int   // 授权事件记录保留天数
+	ComponentMaxRows   int64 // 授权事件记录最多保留行数
+	BizMaxDays         int   // 消息与事件记录保留天数
+	BizMaxRows         int64 // 消息与事件记录最多保留行数
+	DeliveryMaxDays    int   // 转发记录保留天数
+	DeliveryMaxRows    int64 // 转发记录最多保留行数
+	DeadTaskMaxDays    int   // 异步转发死信保留天数 待投递的任务不会被清理
+	DeadTaskMaxRows    int64 // 异步转发死信最多保留行数
+	StatsMaxDays       int   // 消息统计保留天数
+	StatsMaxRows       int64 // 消息统计最多保留行数
+	RuleHistoryMaxDays int   // 转发规则变更历史保留天数
+	RuleHistoryMaxRows int64 // 转发规则变更历史最多保留行数
+}
+
+// Stats 消息统计配置结构体
+type Stats struct {
+	AggregateInterval int // 汇总间隔 单位分钟 为0时不汇总
+}
+
+// TokenRefresh token主动刷新配置结构体
+type TokenRefresh struct {
+	Interval int // 检查间隔 单位秒 为0时不主动刷新
+	Advance  int // 在过期前多久刷新 单位分钟
+}
+
+// Store token、ticket和锁的存储配置结构体
+type Store struct {
+	Type          string // 存储类型 mysql、memory或redis memory只适用于单实例部署
+	RedisAddr     string // redis地址
+	RedisPassword string // redis密码
+	RedisDB       int    // redis库
+	RedisPrefix   string // key的前缀
+	RedisTimeout  int    // 连接和读写超时 单位毫秒
+	RedisMaxIdle  int    // 最多保留的空闲连接数
+}
+
+// InnerService 内部服务配置结构体
+type InnerService struct {
+	RequireClient bool // 获取token和ticket时是否必须使用客户端凭证 为false时兼容白名单和apikey
+}
+
 var ServerConf = &Server{}
 var CommConf = &Comm{}
 var WxApiConf = &WxApi{}
+var WxCallbackConf = &WxCallback{
+	TrustSourceHeader: true,
+	AsyncWorkers:      4,
+	AsyncMaxAttempts:  8,
+	AsyncRetryBackoff: 10,
+	DedupWindow:       60,
+	ReplayQps:         10,
+	ReplyDeadline:     4000,
+}
+var RetentionConf = &Retention{
+	PurgeInterval:   60,
+	PurgeBatchSize:  1000,
+	PurgeBatchPause: 200,
+	DeadTaskMaxDays: 30,
+	StatsMaxDays:    180,
+}
+var StatsConf = &Stats{
+	AggregateInterval: 10,
+}
+var StoreConf = &Store{
+	Type:         "mysql",
+	RedisAddr:    "127.0.0.1:6379",
+	RedisPrefix:  "wxcomponent:",
+	RedisTimeout: 3000,
+	RedisMaxIdle: 8,
+}
+var InnerServiceConf = &InnerService{}
+var TokenRefreshConf = &TokenRefresh{
+	Interval: 60,
+	Advance:  20,
+}
 
 var cfg *ini.File
 
@@ -44,6 +136,12 @@ func init() {
 	mapTo("server", ServerConf)
 	mapTo("comm", CommConf)
 	mapTo("wxapi", WxApiConf)
+	mapTo("wxcallback", WxCallbackConf)
+	mapTo("retention", RetentionConf)
+	mapTo("stats", StatsConf)
+	mapTo("tokenrefresh", TokenRefreshConf)
+	mapTo("store", StoreConf)
+	mapTo("innerservice", InnerServiceConf)
 	if ServerConf.AesKey == "" {
 		ServerConf.AesKey = encrypt.GenerateMd5(os.Getenv("MYSQL_PASSWORD"))
 	}
@@ -15,4 +15,5 @@ var (
 	ErrInvalidType        = &JsonResult{Code: 1009, ErrorMsg: "类型错误"}
 	ErrRequestErr         = &JsonResult{Code: 1010, ErrorMsg: "请求错误"}
 	ErrAuthErrExceedLimit = &JsonResult{Code: 1011, ErrorMsg: "登录失败次数超过限制"}
+	ErrNoPermission       = &JsonResult{Code: 1012, ErrorMsg: "无权限"}
 )
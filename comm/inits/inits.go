@@ -3,9 +3,12 @@ package inits
 import (
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/admin"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/proxy"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/wxcallback"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/store"
 )
 
 type AppOption func() error
@@ -20,7 +23,7 @@ func include(opts ...AppOption) {
 func Init() error {
 
 	// db.Init must be the first
-	include(db.Init, dao.Init, admin.Init, proxy.Init)
+	include(db.Init, store.Init, dao.Init, admin.Init, proxy.Init, wxcallback.Init, wx.InitTokenRefresher)
 
 	for i, opt := range appOpts {
 		log.Infof("[%d]--begin init--", i)
@@ -2,6 +2,7 @@ package wx
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	wxbase "github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/base"
@@ -25,8 +26,14 @@ func GetAuthorizerAccessToken(appid string) (string, error) {
 	return getAccessTokenWithRetry(appid, model.WXTOKENTYPE_AUTH)
 }
 
+// refreshtoken失效的错误码 61003为授权已取消 61023为refreshtoken无效
+const (
+	WXERRCODE_COMPONENT_UNAUTHORIZED = 61003
+	WXERRCODE_INVALID_REFRESHTOKEN   = 61023
+)
+
 func getNewAuthorizerAccessToken(appid string) (string, error) {
-	records, _, err := dao.GetAuthorizerRecords(appid, 0, 1)
+	records, _, err := dao.GetAuthorizerRecords(appid, -1, 0, 1)
 	if err != nil {
 		return "", err
 	}
@@ -39,7 +46,8 @@ func getNewAuthorizerAccessToken(appid string) (string, error) {
 		AuthorizerRefreshToken: records[0].RefreshToken,
 	}
 	var resp authorizerAccessTokenResp
-	_, body, err := PostWxJsonWithComponentToken("/cgi-bin/component/api_authorizer_token", "", req)
+	wxError, body, err := PostWxJsonWithComponentToken("/cgi-bin/component/api_authorizer_token", "", req)
+	updateAuthorizerHealth(appid, wxError, err)
 	if err != nil {
 		return "", err
 	}
@@ -49,3 +57,20 @@ func getNewAuthorizerAccessToken(appid string) (string, error) {
 	}
 	return resp.AuthorizerAccessToken, nil
 }
+
+// updateAuthorizerHealth 按刷新token的结果更新授权状态 网络错误或第三方token无效时不是授权账号的问题 不更新
+func updateAuthorizerHealth(appid string, wxError *WxCommError, err error) {
+	health := model.AUTHORIZERHEALTH_OK
+	var msg string
+	if err != nil {
+		if wxError == nil || wxError.ErrCode == 0 || isInvalidTokenErr(wxError) {
+			return
+		}
+		health = model.AUTHORIZERHEALTH_REFRESHFAIL
+		if wxError.ErrCode == WXERRCODE_COMPONENT_UNAUTHORIZED || wxError.ErrCode == WXERRCODE_INVALID_REFRESHTOKEN {
+			health = model.AUTHORIZERHEALTH_UNAUTHORIZED
+		}
+		msg = fmt.Sprintf("%d %s", wxError.ErrCode, wxError.ErrMsg)
+	}
+	dao.UpdateAuthorizerHealth(appid, health, msg)
+}
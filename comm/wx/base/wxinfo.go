@@ -3,10 +3,10 @@ package wxbase
 import (
 	"os"
 	"strings"
-	"time"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/store"
 )
 
 var appid string
@@ -55,10 +55,14 @@ func SetSecret(s string) error {
 
 // GetTicket 获取最新ticket
 func GetTicket() string {
-	return dao.GetCommKvWithCache("ticket", "", 15*time.Minute)
+	ticket, err := store.Get().GetTicket()
+	if err != nil {
+		log.Error(err)
+	}
+	return ticket
 }
 
 // GetTicket 更新ticket
 func SetTicket(s string) error {
-	return dao.SetCommKvWithCache("ticket", s, 15*time.Minute)
+	return store.Get().SetTicket(s)
 }
@@ -2,12 +2,15 @@ package wx
 
 import (
 	"fmt"
+	"io"
 	"mime/multipart"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/httputils"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	wxbase "github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/base"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/cloudbasetoken"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -24,8 +27,33 @@ var WxJson = jsoniter.Config{
 	TagKey:                 "wx",
 }.Froze()
 
+// 微信判定token无效的错误码 40001为无效 42001为已过期 40014为不合法
+const (
+	WXERRCODE_INVALID_CREDENTIAL = 40001
+	WXERRCODE_TOKEN_EXPIRED      = 42001
+	WXERRCODE_INVALID_TOKEN      = 40014
+)
+
+// isInvalidTokenErr 是否为token无效的错误
+func isInvalidTokenErr(wxError *WxCommError) bool {
+	if wxError == nil {
+		return false
+	}
+	switch wxError.ErrCode {
+	case WXERRCODE_INVALID_CREDENTIAL, WXERRCODE_TOKEN_EXPIRED, WXERRCODE_INVALID_TOKEN:
+		return true
+	}
+	return false
+}
+
 // GetComponentWxApiUrl 拼接微信开放平台的url，带第三方token
 func GetComponentWxApiUrl(path string, query string) (string, error) {
+	url, _, err := genComponentWxApiUrl(path, query)
+	return url, err
+}
+
+// genComponentWxApiUrl 拼接带第三方token的url 同时返回使用的第三方token 使用云开发token或不带token时为空
+func genComponentWxApiUrl(path string, query string) (string, string, error) {
 	if len(query) > 0 {
 		query = "&" + query
 	}
@@ -39,32 +67,83 @@ func GetComponentWxApiUrl(path string, query string) (string, error) {
 
 	if config.WxApiConf.UseCloudBaseAccessToken {
 		return fmt.Sprintf("%s?cloudbase_access_token=%s%s",
-			url, cloudbasetoken.GetCloudBaseAccessToken(), query), nil
+			url, cloudbasetoken.GetCloudBaseAccessToken(), query), "", nil
 	}
 	if config.WxApiConf.UseComponentAccessToken {
 		token, err := GetComponentAccessToken()
 		if err != nil {
 			log.Error(err)
-			return "", err
+			return "", "", err
 		}
 		return fmt.Sprintf("%s?component_access_token=%s%s",
-			url, token, query), nil
+			url, token, query), token, nil
 	}
-	return fmt.Sprintf("%s?%s", url, query), nil
+	return fmt.Sprintf("%s?%s", url, query), "", nil
 }
 
 // GetAuthorizerWxApiUrl 拼接微信开放平台的url，带小程序token
 func GetAuthorizerWxApiUrl(appid string, path string, query string) (string, error) {
+	url, _, err := genAuthorizerWxApiUrl(appid, path, query)
+	return url, err
+}
+
+// genAuthorizerWxApiUrl 拼接带小程序token的url 同时返回使用的token
+func genAuthorizerWxApiUrl(appid string, path string, query string) (string, string, error) {
 	if len(query) > 0 {
 		query = "&" + query
 	}
 	token, err := GetAuthorizerAccessToken(appid)
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return "", "", err
 	}
 	return fmt.Sprintf("https://api.weixin.qq.com%s?access_token=%s%s",
-		path, token, query), nil
+		path, token, query), token, nil
+}
+
+// wxApiCall 用拼接好的url发起一次请求
+type wxApiCall func(url string) (*WxCommError, []byte, error)
+
+// callWithComponentToken 以第三方身份发起请求 token被判定无效时强制刷新后重试一次
+func callWithComponentToken(path string, query string, call wxApiCall) (*WxCommError, []byte, error) {
+	url, token, err := genComponentWxApiUrl(path, query)
+	if err != nil {
+		return nil, []byte{}, err
+	}
+	wxError, body, err := call(url)
+	if token == "" || !isInvalidTokenErr(wxError) {
+		return wxError, body, err
+	}
+	log.Infof("invalid component token, errcode %d, retry", wxError.ErrCode)
+	if _, refreshErr := forceRefreshAccessToken(wxbase.GetAppid(), model.WXTOKENTYPE_OWN, token); refreshErr != nil {
+		log.Error(refreshErr)
+		return wxError, body, err
+	}
+	if url, _, err = genComponentWxApiUrl(path, query); err != nil {
+		return nil, []byte{}, err
+	}
+	return call(url)
+}
+
+// callWithAuthToken 以小程序身份发起请求 token被判定无效时强制刷新后重试一次
+func callWithAuthToken(appid string, path string, query string, call wxApiCall) (*WxCommError, []byte, error) {
+	url, token, err := genAuthorizerWxApiUrl(appid, path, query)
+	if err != nil {
+		return nil, []byte{}, err
+	}
+	wxError, body, err := call(url)
+	if !isInvalidTokenErr(wxError) {
+		return wxError, body, err
+	}
+	log.Infof("invalid authorizer token, appid %s errcode %d, retry", appid, wxError.ErrCode)
+	if _, refreshErr := forceRefreshAccessToken(appid, model.WXTOKENTYPE_AUTH, token); refreshErr != nil {
+		log.Error(refreshErr)
+		return wxError, body, err
+	}
+	if url, _, err = genAuthorizerWxApiUrl(appid, path, query); err != nil {
+		return nil, []byte{}, err
+	}
+	return call(url)
 }
 
 // GetRawWxApiUrl 拼接微信开放平台的url，不带微信令牌
@@ -133,20 +212,16 @@ func getWxApi(url string) (*WxCommError, []byte, error) {
 
 // PostWxJsonWithComponentToken 以第三方身份向微信开放平台发起post请求
 func PostWxJsonWithComponentToken(path string, query string, data interface{}) (*WxCommError, []byte, error) {
-	url, err := GetComponentWxApiUrl(path, query)
-	if err != nil {
-		return nil, []byte{}, err
-	}
-	return postWxJson(url, data)
+	return callWithComponentToken(path, query, func(url string) (*WxCommError, []byte, error) {
+		return postWxJson(url, data)
+	})
 }
 
 // PostWxJsonWithAuthToken 以小程序身份向微信开放平台发起post请求
 func PostWxJsonWithAuthToken(appid string, path string, query string, data interface{}) (*WxCommError, []byte, error) {
-	url, err := GetAuthorizerWxApiUrl(appid, path, query)
-	if err != nil {
-		return nil, []byte{}, err
-	}
-	return postWxJson(url, data)
+	return callWithAuthToken(appid, path, query, func(url string) (*WxCommError, []byte, error) {
+		return postWxJson(url, data)
+	})
 }
 
 // PostWxJsonWithoutToken 向微信开放平台发起post请求
@@ -156,20 +231,12 @@ func PostWxJsonWithoutToken(path string, query string, data interface{}) (*WxCom
 
 // GetWxApiWithComponentToken 以第三方身份向微信开放平台发起get请求
 func GetWxApiWithComponentToken(path string, query string) (*WxCommError, []byte, error) {
-	url, err := GetComponentWxApiUrl(path, query)
-	if err != nil {
-		return nil, []byte{}, err
-	}
-	return getWxApi(url)
+	return callWithComponentToken(path, query, getWxApi)
 }
 
 // GetWxApiWithAuthToken 以小程序身份向微信开放平台发起get请求
 func GetWxApiWithAuthToken(appid string, path string, query string) (*WxCommError, []byte, error) {
-	url, err := GetAuthorizerWxApiUrl(appid, path, query)
-	if err != nil {
-		return nil, []byte{}, err
-	}
-	return getWxApi(url)
+	return callWithAuthToken(appid, path, query, getWxApi)
 }
 
 // GetWxApiWithoutToken 向微信开放平台发起get请求
@@ -180,9 +247,15 @@ func GetWxApiWithoutToken(path string, query string) (*WxCommError, []byte, erro
 // PostWxFormDataWithAuthToken 以小程序身份向微信开放平台发起post请求
 func PostWxFormDataWithAuthToken(appid string, path string, query string,
 	formFile multipart.File, fileName string, fieldName string) (*WxCommError, []byte, error) {
-	url, err := GetAuthorizerWxApiUrl(appid, path, query)
-	if err != nil {
-		return nil, []byte{}, err
-	}
-	return postWxFormData(url, formFile, fileName, fieldName)
+	first := true
+	return callWithAuthToken(appid, path, query, func(url string) (*WxCommError, []byte, error) {
+		// 重试时从头重新读取文件
+		if !first {
+			if _, err := formFile.Seek(0, io.SeekStart); err != nil {
+				return nil, nil, err
+			}
+		}
+		first = false
+		return postWxFormData(url, formFile, fileName, fieldName)
+	})
 }
@@ -0,0 +1,138 @@
+package msgcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const blockSize = 32
+
+// ErrInvalidSignature 签名校验失败
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrInvalidAppid 消息体中的appid与第三方appid不一致
+var ErrInvalidAppid = errors.New("invalid appid")
+
+// MsgCrypt 微信开放平台消息加解密
+type MsgCrypt struct {
+	token  string
+	appid  string
+	aesKey []byte
+}
+
+// NewMsgCrypt 创建加解密实例 encodingAESKey为43位的消息加解密Key
+func NewMsgCrypt(token string, encodingAESKey string, appid string) (*MsgCrypt, error) {
+	aesKey, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, err
+	}
+	if len(aesKey) != 32 {
+		return nil, errors.New("invalid EncodingAESKey")
+	}
+	return &MsgCrypt{token: token, appid: appid, aesKey: aesKey}, nil
+}
+
+// Signature 计算签名 sha1(sort(token, timestamp, nonce, encrypt))
+func Signature(token string, timestamp string, nonce string, encrypt string) string {
+	strs := []string{token, timestamp, nonce}
+	if encrypt != "" {
+		strs = append(strs, encrypt)
+	}
+	sort.Strings(strs)
+	h := sha1.New()
+	h.Write([]byte(strings.Join(strs, "")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyURL 校验url上的signature参数
+func VerifyURL(token string, signature string, timestamp string, nonce string) bool {
+	return token != "" && signature != "" && Signature(token, timestamp, nonce, "") == signature
+}
+
+// Decrypt 校验msg_signature并解密Encrypt字段 返回明文消息
+func (m *MsgCrypt) Decrypt(msgSignature string, timestamp string, nonce string, encrypt string) ([]byte, error) {
+	if Signature(m.token, timestamp, nonce, encrypt) != msgSignature {
+		return nil, ErrInvalidSignature
+	}
+	cipherText, err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) == 0 || len(cipherText)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid cipher text length")
+	}
+	block, err := aes.NewCipher(m.aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plainText := make([]byte, len(cipherText))
+	cipher.NewCBCDecrypter(block, m.aesKey[:aes.BlockSize]).CryptBlocks(plainText, cipherText)
+	if plainText, err = pkcs7UnPadding(plainText); err != nil {
+		return nil, err
+	}
+
+	// random(16B) + msg_len(4B) + msg + appid
+	if len(plainText) < 20 {
+		return nil, errors.New("invalid plain text length")
+	}
+	msgLen := int(binary.BigEndian.Uint32(plainText[16:20]))
+	if len(plainText) < 20+msgLen {
+		return nil, errors.New("invalid msg length")
+	}
+	msg := plainText[20 : 20+msgLen]
+	if m.appid != "" && string(plainText[20+msgLen:]) != m.appid {
+		return nil, ErrInvalidAppid
+	}
+	return msg, nil
+}
+
+// Encrypt 加密消息 返回Encrypt字段以及对应的msg_signature
+func (m *MsgCrypt) Encrypt(msg []byte, timestamp string, nonce string) (string, string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	buf.Write(random)
+	msgLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(msgLen, uint32(len(msg)))
+	buf.Write(msgLen)
+	buf.Write(msg)
+	buf.WriteString(m.appid)
+
+	plainText := pkcs7Padding(buf.Bytes())
+	block, err := aes.NewCipher(m.aesKey)
+	if err != nil {
+		return "", "", err
+	}
+	cipherText := make([]byte, len(plainText))
+	cipher.NewCBCEncrypter(block, m.aesKey[:aes.BlockSize]).CryptBlocks(cipherText, plainText)
+	encrypt := base64.StdEncoding.EncodeToString(cipherText)
+	return encrypt, Signature(m.token, timestamp, nonce, encrypt), nil
+}
+
+func pkcs7Padding(data []byte) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func pkcs7UnPadding(data []byte) ([]byte, error) {
+	length := len(data)
+	if length == 0 {
+		return nil, errors.New("empty data")
+	}
+	padding := int(data[length-1])
+	if padding < 1 || padding > blockSize || padding > length {
+		return nil, errors.New("invalid padding")
+	}
+	return data[:length-padding], nil
+}
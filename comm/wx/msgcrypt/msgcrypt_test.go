@@ -0,0 +1,79 @@
+package msgcrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+const testAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+
+func newTestMsgCrypt(t *testing.T, appid string) *MsgCrypt {
+	m, err := NewMsgCrypt("token", testAESKey, appid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSignature(t *testing.T) {
+	// sha1("1409304348msgtokenxxxxxx")
+	if s := Signature("token", "1409304348", "xxxxxx", "msg"); s != "806e4c681d515f128004f1185df404a729c8183c" {
+		t.Errorf("Signature = %s", s)
+	}
+	signature := Signature("token", "1409304348", "xxxxxx", "")
+	if !VerifyURL("token", signature, "1409304348", "xxxxxx") {
+		t.Error("VerifyURL failed")
+	}
+	if VerifyURL("", signature, "1409304348", "xxxxxx") || VerifyURL("token", signature, "1409304349", "xxxxxx") {
+		t.Error("VerifyURL passed with wrong token or timestamp")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	m := newTestMsgCrypt(t, "wxcomponent")
+	for _, msg := range []string{"", "<xml><MsgType><![CDATA[text]]></MsgType></xml>", strings.Repeat("中", 100)} {
+		encrypt, signature, err := m.Encrypt([]byte(msg), "1409304348", "xxxxxx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature != Signature("token", "1409304348", "xxxxxx", encrypt) {
+			t.Errorf("unexpected signature %s", signature)
+		}
+		plain, err := m.Decrypt(signature, "1409304348", "xxxxxx", encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plain) != msg {
+			t.Errorf("Decrypt = %q, want %q", plain, msg)
+		}
+	}
+}
+
+func TestDecryptError(t *testing.T) {
+	m := newTestMsgCrypt(t, "wxcomponent")
+	encrypt, signature, err := m.Encrypt([]byte("<xml></xml>"), "1409304348", "xxxxxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Decrypt(signature, "1409304349", "xxxxxx", encrypt); err != ErrInvalidSignature {
+		t.Errorf("err = %v, want ErrInvalidSignature", err)
+	}
+	if _, err = newTestMsgCrypt(t, "wxother").Decrypt(signature, "1409304348", "xxxxxx", encrypt); err != ErrInvalidAppid {
+		t.Errorf("err = %v, want ErrInvalidAppid", err)
+	}
+	// 未指定appid时不校验
+	if _, err = newTestMsgCrypt(t, "").Decrypt(signature, "1409304348", "xxxxxx", encrypt); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	badEncrypt := "YWJj"
+	if _, err = m.Decrypt(Signature("token", "1409304348", "xxxxxx", badEncrypt), "1409304348", "xxxxxx",
+		badEncrypt); err == nil {
+		t.Error("want error for invalid cipher text")
+	}
+}
+
+func TestNewMsgCryptInvalidKey(t *testing.T) {
+	if _, err := NewMsgCrypt("token", "short", "wxcomponent"); err == nil {
+		t.Error("want error for invalid EncodingAESKey")
+	}
+}
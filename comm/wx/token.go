@@ -9,8 +9,8 @@ import (
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
-	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/store"
 )
 
 func getAccessTokenWithRetry(appid string, tokenType int) (string, error) {
@@ -39,7 +39,7 @@ func getAccessToken(appid string, tokenType int) (string, error) {
 	}
 
 	// 读数据库
-	record, found, err := dao.GetAccessToken(appid, tokenType)
+	record, found, err := store.Get().GetToken(appid, tokenType)
 	if err != nil {
 		log.Error(err)
 		return "", err
@@ -73,12 +73,12 @@ func getAccessToken(appid string, tokenType int) (string, error) {
 func updateAccessToken(appid string, tokenType int) (string, error) {
 	// 抢锁
 	lockKey := genTokenLockKey(appid, tokenType)
-	if err := dao.Lock(lockKey, gUniqueId, 10*time.Second); err != nil {
+	if err := store.Get().Lock(lockKey, gUniqueId, 10*time.Second); err != nil {
 		log.Error(err)
 		return "", errors.New("lock fail")
 	}
 	// 返回前释放锁
-	defer dao.UnLock(lockKey)
+	defer store.Get().UnLock(lockKey, gUniqueId)
 
 	// 请求新token
 	token, err := getNewAccessToken(appid, tokenType)
@@ -87,13 +87,65 @@ func updateAccessToken(appid string, tokenType int) (string, error) {
 		return "", err
 	}
 
-	// 写入数据库
-	dao.SetAccessToken(&model.WxToken{
+	saveAccessToken(appid, tokenType, token)
+	return token, nil
+}
+
+// saveAccessToken 新token写入数据库
+func saveAccessToken(appid string, tokenType int, token string) {
+	store.Get().SetToken(&model.WxToken{
 		Type:       tokenType,
 		Appid:      appid,
 		Token:      token,
 		Expiretime: time.Now().Add(2 * time.Hour).Add(-time.Minute),
 	})
+}
+
+// forceRefreshAccessToken token被微信判定无效时删除缓存和数据库记录并重新获取
+func forceRefreshAccessToken(appid string, tokenType int, invalidToken string) (string, error) {
+	db.GetCache().Delete(genTokenKey(appid, tokenType))
+	var token string
+	var err error
+	for i := 0; i < 3; i++ {
+		if token, err = refreshInvalidToken(appid, tokenType, invalidToken); err != nil &&
+			err.Error() == "lock fail" {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		break
+	}
+	return token, err
+}
+
+func refreshInvalidToken(appid string, tokenType int, invalidToken string) (string, error) {
+	lockKey := genTokenLockKey(appid, tokenType)
+	if err := store.Get().Lock(lockKey, gUniqueId, 10*time.Second); err != nil {
+		log.Error(err)
+		return "", errors.New("lock fail")
+	}
+	defer store.Get().UnLock(lockKey, gUniqueId)
+
+	cacheCli := db.GetCache()
+	cacheKey := genTokenKey(appid, tokenType)
+	// 其他实例或请求已刷新时直接使用新token
+	record, found, err := store.Get().GetToken(appid, tokenType)
+	if err != nil {
+		return "", err
+	}
+	if found && record.Token != invalidToken && record.Expiretime.After(time.Now()) {
+		cacheCli.Set(cacheKey, record.Token, 5*time.Minute)
+		return record.Token, nil
+	}
+	if err = store.Get().DelToken(appid, tokenType, invalidToken); err != nil {
+		return "", err
+	}
+	token, err := getNewAccessToken(appid, tokenType)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	saveAccessToken(appid, tokenType, token)
+	cacheCli.Set(cacheKey, token, 5*time.Minute)
 	return token, nil
 }
 
@@ -0,0 +1,170 @@
+package wx
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/store"
+)
+
+// 主动刷新：定期检查wxtoken中的所有token 在过期前提前刷新 避免低频调用时由请求方承担刷新耗时
+// 多实例部署时通过与被动刷新相同的锁保证同一token只由一个实例刷新
+
+// maxRefreshBackoff 刷新失败后重试间隔的上限
+const maxRefreshBackoff = 30 * time.Minute
+
+// TokenRefreshStatus 单个token的主动刷新状态 错误信息只记录在执行刷新的实例
+type TokenRefreshStatus struct {
+	Type            int    `json:"type"`
+	Appid           string `json:"appid"`
+	ExpireTime      int64  `json:"expireTime"`
+	NextRefreshTime int64  `json:"nextRefreshTime"`
+	LastRefreshTime int64  `json:"lastRefreshTime"` // 本实例最近一次刷新成功的时间
+	LastError       string `json:"lastError"`
+	LastErrorTime   int64  `json:"lastErrorTime"`
+	Failures        int    `json:"failures"` // 连续失败次数 成功后清零
+}
+
+var tokenRefreshMutex sync.Mutex
+var tokenRefreshStatus = make(map[string]*TokenRefreshStatus)
+
+// GetTokenRefreshStatus 获取所有token的主动刷新状态
+func GetTokenRefreshStatus() []TokenRefreshStatus {
+	tokenRefreshMutex.Lock()
+	defer tokenRefreshMutex.Unlock()
+	res := make([]TokenRefreshStatus, 0, len(tokenRefreshStatus))
+	for _, v := range tokenRefreshStatus {
+		res = append(res, *v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Type != res[j].Type {
+			return res[i].Type > res[j].Type
+		}
+		return res[i].Appid < res[j].Appid
+	})
+	return res
+}
+
+// GetTokenRefreshInstance 当前实例的标识 与刷新锁的持有者相同
+func GetTokenRefreshInstance() string {
+	return gUniqueId
+}
+
+// InitTokenRefresher 启动token主动刷新任务
+func InitTokenRefresher() error {
+	if config.TokenRefreshConf.Interval <= 0 {
+		log.Info("token refresher disabled")
+		return nil
+	}
+	go startTokenRefreshTask(time.Duration(config.TokenRefreshConf.Interval) * time.Second)
+	return nil
+}
+
+func startTokenRefreshTask(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		refreshExpiringTokens()
+	}
+}
+
+// refreshExpiringTokens 刷新已到刷新时间的token
+func refreshExpiringTokens() {
+	records, err := store.Get().ListTokens()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, record := range records {
+		status := syncTokenRefreshStatus(record)
+		if now.Unix() < status.NextRefreshTime || !needRefresh(record) {
+			continue
+		}
+		refreshToken(record.Appid, record.Type)
+	}
+	removeStaleTokenRefreshStatus(records)
+}
+
+// syncTokenRefreshStatus 按数据库中的过期时间更新刷新计划 返回状态的副本
+func syncTokenRefreshStatus(record *model.WxToken) TokenRefreshStatus {
+	tokenRefreshMutex.Lock()
+	defer tokenRefreshMutex.Unlock()
+	key := genTokenKey(record.Appid, record.Type)
+	status, ok := tokenRefreshStatus[key]
+	if !ok {
+		status = &TokenRefreshStatus{Type: record.Type, Appid: record.Appid}
+		tokenRefreshStatus[key] = status
+	}
+	status.ExpireTime = record.Expiretime.Unix()
+	advance := time.Duration(config.TokenRefreshConf.Advance) * time.Minute
+	status.NextRefreshTime = record.Expiretime.Add(-advance).Unix()
+	if status.Failures > 0 {
+		// 连续失败时按指数退避重试 不晚于正常的刷新时间
+		backoff := time.Duration(config.TokenRefreshConf.Interval) * time.Second << (status.Failures - 1)
+		if backoff <= 0 || backoff > maxRefreshBackoff {
+			backoff = maxRefreshBackoff
+		}
+		if retry := status.LastErrorTime + int64(backoff/time.Second); retry > status.NextRefreshTime {
+			status.NextRefreshTime = retry
+		}
+	}
+	return *status
+}
+
+// removeStaleTokenRefreshStatus 删除数据库中已不存在的token的状态
+func removeStaleTokenRefreshStatus(records []*model.WxToken) {
+	keys := make(map[string]bool, len(records))
+	for _, record := range records {
+		keys[genTokenKey(record.Appid, record.Type)] = true
+	}
+	tokenRefreshMutex.Lock()
+	defer tokenRefreshMutex.Unlock()
+	for key := range tokenRefreshStatus {
+		if !keys[key] {
+			delete(tokenRefreshStatus, key)
+		}
+	}
+}
+
+// needRefresh 已取消授权或已删除的授权账号不再主动刷新 重新授权后恢复
+func needRefresh(record *model.WxToken) bool {
+	if record.Type != model.WXTOKENTYPE_AUTH {
+		return true
+	}
+	authorizer, err := dao.GetAuthorizerRecordWithCache(record.Appid)
+	if err != nil {
+		return true
+	}
+	return authorizer != nil && authorizer.Health != model.AUTHORIZERHEALTH_UNAUTHORIZED
+}
+
+// refreshToken 刷新token并更新本实例的缓存 锁被其他实例持有时跳过 下个周期按新的过期时间重新计划
+func refreshToken(appid string, tokenType int) {
+	token, err := updateAccessToken(appid, tokenType)
+	if err != nil && err.Error() == "lock fail" {
+		log.Debugf("token refresh skipped, appid %s type %d", appid, tokenType)
+		return
+	}
+	tokenRefreshMutex.Lock()
+	defer tokenRefreshMutex.Unlock()
+	status, ok := tokenRefreshStatus[genTokenKey(appid, tokenType)]
+	if !ok {
+		return
+	}
+	if err != nil {
+		log.Errorf("token refresh fail, appid %s type %d, %v", appid, tokenType, err)
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now().Unix()
+		status.Failures++
+		return
+	}
+	log.Infof("token refreshed, appid %s type %d", appid, tokenType)
+	db.GetCache().Set(genTokenKey(appid, tokenType), token, 5*time.Minute)
+	status.LastRefreshTime = time.Now().Unix()
+	status.Failures = 0
+}
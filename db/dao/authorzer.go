@@ -10,6 +10,7 @@ import (
 )
 
 const authorizerTableName = "authorizers"
+const authorizerCacheKeyPrefix = "authorizer_"
 
 // CreateOrUpdateAuthorizerRecord 创建或更新授权账号信息
 func CreateOrUpdateAuthorizerRecord(record *model.Authorizer) error {
@@ -52,19 +53,43 @@ func ClearAuthorizerRecordsBefore(time time.Time) error {
 	return nil
 }
 
-// GetAuthorizerRecords 获取授权账号记录
-func GetAuthorizerRecords(appid string, offset int, limit int) ([]*model.Authorizer, int64, error) {
+// GetAuthorizerRecords 获取授权账号记录 health小于0时不按授权状态筛选
+func GetAuthorizerRecords(appid string, health int, offset int, limit int) ([]*model.Authorizer, int64, error) {
 	var records = []*model.Authorizer{}
 	cli := db.Get()
 	result := cli.Table(authorizerTableName)
 	if appid != "" {
 		result = result.Where("appid = ?", appid)
 	}
+	if health >= 0 {
+		result = result.Where("health = ?", health)
+	}
 	var count int64
 	result = result.Count(&count).Offset(offset).Limit(limit).Find(&records)
 	return records, count, result.Error
 }
 
+// GetAuthorizerRecordWithCache 获取单个授权账号记录 有缓存 不存在时返回nil
+func GetAuthorizerRecordWithCache(appid string) (*model.Authorizer, error) {
+	cacheKey := authorizerCacheKeyPrefix + appid
+	cacheCli := db.GetCache()
+	if value, found := cacheCli.Get(cacheKey); found {
+		return value.(*model.Authorizer), nil
+	}
+	var records = []*model.Authorizer{}
+	cli := db.Get()
+	if result := cli.Table(authorizerTableName).Where("appid = ?", appid).Limit(1).Find(&records); result.Error != nil {
+		log.Error(result.Error)
+		return nil, result.Error
+	}
+	var record *model.Authorizer
+	if len(records) != 0 {
+		record = records[0]
+	}
+	cacheCli.Set(cacheKey, record, time.Minute)
+	return record, nil
+}
+
 // DelAuthorizerRecord 删除授权账号记录
 func DelAuthorizerRecord(appid string) error {
 	var err error
@@ -91,3 +116,35 @@ func GetDevWeAppRecords(offset int, limit int, appid string) ([]*model.Authorize
 	result = result.Count(&count).Offset(offset).Limit(limit).Find(&records)
 	return records, count, result.Error
 }
+
+// UpdateAuthorizerInfo 更新授权账号的基本信息 不改变refreshtoken和授权时间
+func UpdateAuthorizerInfo(record *model.Authorizer) error {
+	cli := db.Get()
+	if err := cli.Table(authorizerTableName).Where("appid = ?", record.Appid).
+		Select("apptype", "servicetype", "nickname", "username", "headimg", "qrcodeurl",
+			"principalname", "funcinfo", "verifyinfo").
+		Updates(record).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateAuthorizerHealth 更新授权状态 状态未变化时不写入
+func UpdateAuthorizerHealth(appid string, health int, msg string) error {
+	if len(msg) > 256 {
+		msg = msg[:256]
+	}
+	cli := db.Get()
+	result := cli.Table(authorizerTableName).Where("appid = ? AND (health <> ? OR healthmsg <> ?)", appid, health, msg).
+		Updates(map[string]interface{}{"health": health, "healthmsg": msg})
+	if result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected != 0 {
+		log.Infof("authorizer %s health %d, %s", appid, health, msg)
+		db.GetCache().Delete(authorizerCacheKeyPrefix + appid)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package dao
+
+import (
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/patrickmn/go-cache"
+)
+
+const autoReplyTableName = "wxcallback_autoreply"
+const autoReplyCacheKey = "autoreply_rules"
+
+// GetAutoReplyRuleList 获取自动回复规则
+func GetAutoReplyRuleList(appid string, replyType int, offset int, limit int) ([]*model.WxAutoReplyRule, int64, error) {
+	var records = []*model.WxAutoReplyRule{}
+	cli := db.Get()
+	result := cli.Table(autoReplyTableName)
+	if appid != "" {
+		result = result.Where("appid = ?", appid)
+	}
+	if replyType != 0 {
+		result = result.Where("type = ?", replyType)
+	}
+	var count int64
+	result = result.Count(&count).Order("id").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
+
+// AddAutoReplyRule 添加自动回复规则
+func AddAutoReplyRule(record *model.WxAutoReplyRule) error {
+	cli := db.Get()
+	if result := cli.Table(autoReplyTableName).Create(record); result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	db.GetCache().Delete(autoReplyCacheKey)
+	return nil
+}
+
+// UpdateAutoReplyRule 更新自动回复规则
+func UpdateAutoReplyRule(record *model.WxAutoReplyRule) error {
+	cli := db.Get()
+	if result := cli.Table(autoReplyTableName).
+		Where("id = ?", record.ID).
+		Select("name", "appid", "type", "matchtype", "keyword", "replytype", "info", "open").
+		Updates(record); result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	db.GetCache().Delete(autoReplyCacheKey)
+	return nil
+}
+
+// DelAutoReplyRule 删除自动回复规则
+func DelAutoReplyRule(id int32) error {
+	cli := db.Get()
+	if result := cli.Table(autoReplyTableName).
+		Where("id = ?", id).Delete(&model.WxAutoReplyRule{}); result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	db.GetCache().Delete(autoReplyCacheKey)
+	return nil
+}
+
+// GetAutoReplyRulesWithCache 获取该appid可用的自动回复规则 指定appid的规则排在前面 有缓存
+func GetAutoReplyRulesWithCache(appid string, replyType int) ([]*model.WxAutoReplyRule, error) {
+	rules, err := getAllAutoReplyRulesWithCache()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.WxAutoReplyRule, 0)
+	for _, v := range rules {
+		if v.Open != 0 && v.Type == replyType && v.Appid == appid {
+			res = append(res, v)
+		}
+	}
+	for _, v := range rules {
+		if v.Open != 0 && v.Type == replyType && v.Appid == "" {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+func getAllAutoReplyRulesWithCache() ([]*model.WxAutoReplyRule, error) {
+	cacheCli := db.GetCache()
+	if value, found := cacheCli.Get(autoReplyCacheKey); found {
+		return value.([]*model.WxAutoReplyRule), nil
+	}
+	var records = []*model.WxAutoReplyRule{}
+	cli := db.Get()
+	if result := cli.Table(autoReplyTableName).Order("id").Find(&records); result.Error != nil {
+		log.Error(result.Error)
+		return nil, result.Error
+	}
+	for _, v := range records {
+		if err := v.Compile(); err != nil {
+			log.Errorf("invalid autoreply keyword, id %d, %v", v.ID, err)
+		}
+	}
+	cacheCli.Set(autoReplyCacheKey, records, cache.DefaultExpiration)
+	return records, nil
+}
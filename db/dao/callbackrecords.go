@@ -2,11 +2,13 @@ package dao
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"gorm.io/gorm"
 )
 
 const componentTableName = "wxcallback_component"
@@ -26,20 +28,79 @@ func AddComponentCallBackRecord(callbackMessage *model.WxCallbackComponentRecord
 	return nil
 }
 
+// ComponentRecordFilter 第三方事件记录的筛选条件 为空的字段不筛选
+type ComponentRecordFilter struct {
+	StartTime time.Time
+	EndTime   time.Time
+	InfoType  string
+	Keyword   string // 消息内容包含的字符串
+	JsonField string // 消息内容中的字段 嵌套字段用.分隔
+	JsonValue string
+}
+
 // GetComponentCallBackRecordList 获取第三方事件记录
-func GetComponentCallBackRecordList(startTime time.Time, endTime time.Time,
-	infoType string, offset int, limit int) ([]*model.WxCallbackComponentRecord, int64, error) {
+func GetComponentCallBackRecordList(filter *ComponentRecordFilter,
+	offset int, limit int) ([]*model.WxCallbackComponentRecord, int64, error) {
 	var records = []*model.WxCallbackComponentRecord{}
-	cli := db.Get()
-	result := cli.Table(componentTableName).Where("receivetime between ? and ?", startTime, endTime)
-	if infoType != "" {
-		result = result.Where("infotype = ?", infoType)
-	}
+	result := componentRecordFilter(filter)
 	var count int64
 	result = result.Count(&count).Order("receivetime desc").Offset(offset).Limit(limit).Find(&records)
 	return records, count, result.Error
 }
 
+// GetComponentCallBackDupTotal 获取第三方事件被丢弃的重复推送总数
+func GetComponentCallBackDupTotal(filter *ComponentRecordFilter) (int64, error) {
+	var total int64
+	result := componentRecordFilter(filter).
+		Select("COALESCE(SUM(dupcount), 0)").Scan(&total)
+	return total, result.Error
+}
+
+// IncComponentCallBackDupCount 时间窗口内已有相同消息时累计重复次数 返回是否重复
+func IncComponentCallBackDupCount(dedupKey string, since time.Time) (bool, error) {
+	return incCallBackDupCount(componentTableName, dedupKey, since)
+}
+
+// GetComponentCallBackRecordById 通过id获取第三方事件记录
+func GetComponentCallBackRecordById(id int64) (*model.WxCallbackComponentRecord, error) {
+	var record *model.WxCallbackComponentRecord
+	cli := db.Get()
+	result := cli.Table(componentTableName).Where("id = ?", id).Take(&record)
+	return record, result.Error
+}
+
+// GetComponentCallBackRecordsAfter 按id顺序获取afterId之后的第三方事件记录
+func GetComponentCallBackRecordsAfter(filter *ComponentRecordFilter,
+	afterId int64, limit int) ([]*model.WxCallbackComponentRecord, error) {
+	var records = []*model.WxCallbackComponentRecord{}
+	result := componentRecordFilter(filter).
+		Where("id > ?", afterId).Order("id").Limit(limit).Find(&records)
+	return records, result.Error
+}
+
+func componentRecordFilter(filter *ComponentRecordFilter) *gorm.DB {
+	cli := db.Get()
+	result := cli.Table(componentTableName).Where("receivetime between ? and ?", filter.StartTime, filter.EndTime)
+	if filter.InfoType != "" {
+		result = result.Where("infotype = ?", filter.InfoType)
+	}
+	return postBodyFilter(result, filter.Keyword, filter.JsonField, filter.JsonValue)
+}
+
+// postBodyFilter 按消息内容筛选 json字段只匹配合法json的消息
+func postBodyFilter(result *gorm.DB, keyword string, jsonField string, jsonValue string) *gorm.DB {
+	if keyword != "" {
+		result = result.Where("postbody LIKE ?", "%"+likeEscaper.Replace(keyword)+"%")
+	}
+	if jsonField != "" {
+		result = result.Where("JSON_VALID(postbody) AND JSON_UNQUOTE(JSON_EXTRACT(postbody, ?)) = ?",
+			"$."+jsonField, jsonValue)
+	}
+	return result
+}
+
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
 // AddBizCallBackRecord 增加小程序事件记录
 func AddBizCallBackRecord(callbackMessage *model.WxCallbackBizRecord) error {
 	fmt.Println("[AddBizCallBackRecord]" + callbackMessage.ToUserName)
@@ -54,22 +115,157 @@ func AddBizCallBackRecord(callbackMessage *model.WxCallbackBizRecord) error {
 	return nil
 }
 
+// BizRecordFilter 小程序事件记录的筛选条件 为空的字段不筛选
+type BizRecordFilter struct {
+	StartTime    time.Time
+	EndTime      time.Time
+	Appid        string
+	MsgType      string
+	Event        string
+	FromUserName string
+	Keyword      string // 消息内容包含的字符串
+	JsonField    string // 消息内容中的字段 嵌套字段用.分隔
+	JsonValue    string
+}
+
 // GetBizCallBackRecordList 获取小程序事件记录
-func GetBizCallBackRecordList(startTime time.Time, endTime time.Time, appid string,
-	msgType string, event string, offset int, limit int) ([]*model.WxCallbackBizRecord, int64, error) {
+func GetBizCallBackRecordList(filter *BizRecordFilter,
+	offset int, limit int) ([]*model.WxCallbackBizRecord, int64, error) {
 	var records = []*model.WxCallbackBizRecord{}
+	result := bizRecordFilter(filter)
+	var count int64
+	result = result.Count(&count).Order("receivetime desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
+
+// GetBizCallBackDupTotal 获取小程序事件被丢弃的重复推送总数
+func GetBizCallBackDupTotal(filter *BizRecordFilter) (int64, error) {
+	var total int64
+	result := bizRecordFilter(filter).
+		Select("COALESCE(SUM(dupcount), 0)").Scan(&total)
+	return total, result.Error
+}
+
+// IncBizCallBackDupCount 时间窗口内已有相同消息时累计重复次数 返回是否重复
+func IncBizCallBackDupCount(dedupKey string, since time.Time) (bool, error) {
+	return incCallBackDupCount(bizTableName, dedupKey, since)
+}
+
+// GetBizCallBackRecordById 通过id获取小程序事件记录
+func GetBizCallBackRecordById(id int64) (*model.WxCallbackBizRecord, error) {
+	var record *model.WxCallbackBizRecord
 	cli := db.Get()
-	result := cli.Table(bizTableName).Where("receivetime between ? and ?", startTime, endTime)
-	if appid != "" {
-		result = result.Where("appid = ?", appid)
+	result := cli.Table(bizTableName).Where("id = ?", id).Take(&record)
+	return record, result.Error
+}
+
+// GetBizCallBackRecordsAfter 按id顺序获取afterId之后的小程序事件记录
+func GetBizCallBackRecordsAfter(filter *BizRecordFilter,
+	afterId int64, limit int) ([]*model.WxCallbackBizRecord, error) {
+	var records = []*model.WxCallbackBizRecord{}
+	result := bizRecordFilter(filter).
+		Where("id > ?", afterId).Order("id").Limit(limit).Find(&records)
+	return records, result.Error
+}
+
+func bizRecordFilter(filter *BizRecordFilter) *gorm.DB {
+	cli := db.Get()
+	result := cli.Table(bizTableName).Where("receivetime between ? and ?", filter.StartTime, filter.EndTime)
+	if filter.Appid != "" {
+		result = result.Where("appid = ?", filter.Appid)
+	}
+	if filter.MsgType != "" {
+		result = result.Where("msgtype = ?", filter.MsgType)
+	}
+	if filter.Event != "" {
+		result = result.Where("event = ?", filter.Event)
+	}
+	if filter.FromUserName != "" {
+		result = result.Where("fromusername = ?", filter.FromUserName)
+	}
+	return postBodyFilter(result, filter.Keyword, filter.JsonField, filter.JsonValue)
+}
+
+const dedupTableName = "wxcallback_dedup"
+
+// RegisterCallBackDedupKey 登记消息的去重key 去重窗口内已登记过时返回true
+// 依靠唯一索引和INSERT IGNORE 并发的重试推送中只有一个能登记成功
+func RegisterCallBackDedupKey(dedupKey string, since time.Time) (bool, error) {
+	cli := db.Get()
+	now := time.Now()
+	result := cli.Exec("INSERT IGNORE INTO `"+dedupTableName+"` (`dedupkey`, `receivetime`) VALUES (?, ?)",
+		dedupKey, now)
+	if result.Error != nil {
+		log.Error(result.Error)
+		return false, result.Error
+	}
+	if result.RowsAffected != 0 {
+		return false, nil
+	}
+	// 已超过去重窗口的key重新登记 条件更新同样只有一个请求能成功
+	result = cli.Table(dedupTableName).Where("dedupkey = ? and receivetime < ?", dedupKey, since).
+		Update("receivetime", now)
+	if result.Error != nil {
+		log.Error(result.Error)
+		return false, result.Error
+	}
+	return result.RowsAffected == 0, nil
+}
+
+// DelCallBackDedupKey 删除登记的去重key 消息处理失败时调用 微信重试时可再次处理
+func DelCallBackDedupKey(dedupKey string) error {
+	cli := db.Get()
+	if err := cli.Exec("DELETE FROM `"+dedupTableName+"` WHERE `dedupkey` = ?", dedupKey).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// PurgeCallBackDedupKeys 删除已超过去重窗口的key
+func PurgeCallBackDedupKeys(since time.Time) (int64, error) {
+	return purgeInBatches(dedupTableName, "`receivetime` < ?", since)
+}
+
+func incCallBackDupCount(table string, dedupKey string, since time.Time) (bool, error) {
+	cli := db.Get()
+	result := cli.Table(table).Where("dedupkey = ? and receivetime >= ?", dedupKey, since).
+		Update("dupcount", gorm.Expr("dupcount + ?", 1))
+	if result.Error != nil {
+		log.Error(result.Error)
+		return false, result.Error
 	}
-	if msgType != "" {
-		result = result.Where("msgtype = ?", msgType)
+	return result.RowsAffected != 0, nil
+}
+
+const deliveryTableName = "wxcallback_delivery"
+
+// AddCallBackDeliveryRecord 增加消息转发记录
+func AddCallBackDeliveryRecord(record *model.WxCallbackDeliveryRecord) error {
+	cli := db.Get()
+	if err := cli.Table(deliveryTableName).Create(record).Error; err != nil {
+		log.Error(err)
+		return err
 	}
-	if event != "" {
-		result = result.Where("event = ?", event)
+	return nil
+}
+
+// GetCallBackDeliveryRecordList 获取消息转发记录
+func GetCallBackDeliveryRecordList(startTime time.Time, endTime time.Time, ruleId int32, appid string,
+	result int, offset int, limit int) ([]*model.WxCallbackDeliveryRecord, int64, error) {
+	var records = []*model.WxCallbackDeliveryRecord{}
+	cli := db.Get()
+	query := cli.Table(deliveryTableName).Where("createtime between ? and ?", startTime, endTime)
+	if ruleId != 0 {
+		query = query.Where("ruleid = ?", ruleId)
+	}
+	if appid != "" {
+		query = query.Where("appid = ?", appid)
+	}
+	if result != 0 {
+		query = query.Where("result = ?", result)
 	}
 	var count int64
-	result = result.Count(&count).Order("receivetime desc").Offset(offset).Limit(limit).Find(&records)
-	return records, count, result.Error
+	query = query.Count(&count).Order("id desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, query.Error
 }
@@ -1,16 +1,17 @@
 package dao
 
 import (
-	"fmt"
-
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
 	"github.com/patrickmn/go-cache"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const callbackRuleTableName = "wxcallback_rules"
+const callbackRuleHistoryTableName = "wxcallback_rules_history"
+const callbackRuleCacheKey = "cb_rules"
 
 // GetWxCallBackRules 获取所有转发规则
 func GetWxCallBackRuleList(offset int, limit int, callbackType int) ([]*model.WxCallbackRule, int64, error) {
@@ -27,75 +28,217 @@ func GetWxCallBackRuleList(offset int, limit int, callbackType int) ([]*model.Wx
 	return records, count, result.Error
 }
 
-// UpdateWxCallBackRule 更新转发规则
-func UpdateWxCallBackRule(record *model.WxCallbackRule) error {
-	cli := db.Get()
-	if result := cli.Table(callbackRuleTableName).
-		Where("id = ?", record.ID).
-		Select("name", "infotype", "msgtype", "event", "type", "open", "Info").
-		Updates(record); result.Error != nil {
-		log.Error(result.Error)
-		return result.Error
-	}
-	cacheCli := db.GetCache()
-	key := genCallBackRuleKey(record.InfoType, record.MsgType, record.Event)
-	cacheCli.Set(key, record, cache.DefaultExpiration)
-	return nil
+var callbackRuleColumns = []string{"name", "infotype", "msgtype", "event", "appids", "type", "open", "async", "info",
+	"conditions", "transform", "replydeadline"}
+
+// UpdateWxCallBackRule 更新转发规则 operator为操作的管理员 记录在变更历史中
+func UpdateWxCallBackRule(record *model.WxCallbackRule, operator string) error {
+	return withWxCallBackRuleTx(func(tx *gorm.DB) error {
+		return UpdateWxCallBackRuleWithTx(tx, record, operator)
+	})
+}
+
+// UpdateWxCallBackRuleWithTx 在事务中更新转发规则 提交后需调用ClearWxCallBackRuleCache
+func UpdateWxCallBackRuleWithTx(tx *gorm.DB, record *model.WxCallbackRule, operator string) error {
+	return changeWxCallBackRule(tx, record.ID, model.RULEACTION_UPDATE, operator, 0,
+		func(tx *gorm.DB, before *model.WxCallbackRule) error {
+			if before == nil {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Table(callbackRuleTableName).Where("id = ?", record.ID).
+				Select(callbackRuleColumns).Updates(record).Error
+		})
 }
 
 // AddWxCallBackRule 添加转发规则
-func AddWxCallBackRule(record *model.WxCallbackRule) error {
-	cli := db.Get()
-	if result := cli.Table(callbackRuleTableName).Create(record); result.Error != nil {
-		log.Error(result.Error)
-		return result.Error
+func AddWxCallBackRule(record *model.WxCallbackRule, operator string) error {
+	return withWxCallBackRuleTx(func(tx *gorm.DB) error {
+		return AddWxCallBackRuleWithTx(tx, record, operator)
+	})
+}
+
+// AddWxCallBackRuleWithTx 在事务中添加转发规则 提交后需调用ClearWxCallBackRuleCache
+func AddWxCallBackRuleWithTx(tx *gorm.DB, record *model.WxCallbackRule, operator string) error {
+	if err := tx.Table(callbackRuleTableName).Create(record).Error; err != nil {
+		return err
 	}
-	cacheCli := db.GetCache()
-	key := genCallBackRuleKey(record.InfoType, record.MsgType, record.Event)
-	cacheCli.Set(key, record, cache.DefaultExpiration)
+	after, err := takeWxCallBackRule(tx, record.ID, false)
+	if err != nil {
+		return err
+	}
+	return addWxCallBackRuleHistory(tx, &model.WxCallbackRuleHistory{
+		RuleID:    record.ID,
+		Action:    model.RULEACTION_CREATE,
+		Operator:  operator,
+		AfterRule: model.GenRuleSnapshot(after),
+	})
+}
+
+// ClearWxCallBackRuleCache 清除规则缓存 规则变更后调用
+func ClearWxCallBackRuleCache() {
+	db.GetCache().Delete(callbackRuleCacheKey)
+}
+
+// withWxCallBackRuleTx 在事务中变更规则 成功后清除规则缓存
+func withWxCallBackRuleTx(change func(tx *gorm.DB) error) error {
+	if err := db.Get().Transaction(change); err != nil {
+		log.Error(err)
+		return err
+	}
+	ClearWxCallBackRuleCache()
 	return nil
 }
 
-// DelWxCallBackRule 删除转发规则
-func DelWxCallBackRule(id int32) error {
+// DelWxCallBackRule 删除转发规则 删除前的内容保留在变更历史中
+func DelWxCallBackRule(id int32, operator string) error {
+	return withWxCallBackRuleTx(func(tx *gorm.DB) error {
+		return changeWxCallBackRule(tx, id, model.RULEACTION_DELETE, operator, 0,
+			func(tx *gorm.DB, before *model.WxCallbackRule) error {
+				if before == nil {
+					return gorm.ErrRecordNotFound
+				}
+				return tx.Table(callbackRuleTableName).Where("id = ?", id).Delete(&model.WxCallbackRule{}).Error
+			})
+	})
+}
+
+// RestoreWxCallBackRule 把规则恢复为历史版本的内容 规则已删除时按原id重新创建
+func RestoreWxCallBackRule(record *model.WxCallbackRule, fromVersion int, operator string) error {
+	return withWxCallBackRuleTx(func(tx *gorm.DB) error {
+		return changeWxCallBackRule(tx, record.ID, model.RULEACTION_RESTORE, operator, fromVersion,
+			func(tx *gorm.DB, before *model.WxCallbackRule) error {
+				if before == nil {
+					return tx.Table(callbackRuleTableName).Create(record).Error
+				}
+				return tx.Table(callbackRuleTableName).Where("id = ?", record.ID).
+					Select(callbackRuleColumns).Updates(record).Error
+			})
+	})
+}
+
+// changeWxCallBackRule 在事务中锁住规则后执行变更 并记录变更前后的内容 规则不存在时before为nil
+func changeWxCallBackRule(tx *gorm.DB, id int32, action string, operator string, fromVersion int,
+	change func(tx *gorm.DB, before *model.WxCallbackRule) error) error {
+	before, err := takeWxCallBackRule(tx, id, true)
+	if err != nil {
+		return err
+	}
+	if err = change(tx, before); err != nil {
+		return err
+	}
+	after, err := takeWxCallBackRule(tx, id, false)
+	if err != nil {
+		return err
+	}
+	return addWxCallBackRuleHistory(tx, &model.WxCallbackRuleHistory{
+		RuleID:      id,
+		Action:      action,
+		Operator:    operator,
+		FromVersion: fromVersion,
+		BeforeRule:  model.GenRuleSnapshot(before),
+		AfterRule:   model.GenRuleSnapshot(after),
+	})
+}
+
+// takeWxCallBackRule 事务内读取规则 不存在时返回nil
+func takeWxCallBackRule(tx *gorm.DB, id int32, lock bool) (*model.WxCallbackRule, error) {
+	var records = []*model.WxCallbackRule{}
+	query := tx.Table(callbackRuleTableName)
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	if err := query.Where("id = ?", id).Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return records[0], nil
+}
+
+// addWxCallBackRuleHistory 写入变更记录 版本号为该规则的上一版本加1
+func addWxCallBackRuleHistory(tx *gorm.DB, history *model.WxCallbackRuleHistory) error {
+	var version int
+	if err := tx.Table(callbackRuleHistoryTableName).Where("ruleid = ?", history.RuleID).
+		Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return err
+	}
+	history.Version = version + 1
+	return tx.Table(callbackRuleHistoryTableName).Create(history).Error
+}
+
+// GetWxCallBackRuleHistory 获取规则的变更记录 按版本倒序
+func GetWxCallBackRuleHistory(ruleId int32, offset int, limit int) ([]*model.WxCallbackRuleHistory, int64, error) {
+	var records = []*model.WxCallbackRuleHistory{}
 	cli := db.Get()
-	var record *model.WxCallbackRule
-	if result := cli.Table(callbackRuleTableName).
-		Where("id = ?", id).Delete(&record); result.Error != nil {
-		log.Error(result.Error)
-		return result.Error
+	var count int64
+	result := cli.Table(callbackRuleHistoryTableName).Where("ruleid = ?", ruleId).Count(&count).
+		Order("version desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
+
+// GetWxCallBackRuleHistoryVersion 获取规则的某个历史版本
+func GetWxCallBackRuleHistoryVersion(ruleId int32, version int) (*model.WxCallbackRuleHistory, error) {
+	var record *model.WxCallbackRuleHistory
+	cli := db.Get()
+	result := cli.Table(callbackRuleHistoryTableName).
+		Where("ruleid = ? and version = ?", ruleId, version).
+		Take(&record)
+	return record, result.Error
+}
+
+// HasSameWxCallBackRule 是否已存在匹配条件相同的其他规则
+func HasSameWxCallBackRule(record *model.WxCallbackRule) (bool, error) {
+	var count int64
+	cli := db.Get()
+	result := cli.Table(callbackRuleTableName).
+		Where("infotype = ? and msgtype = ? and event = ? and appids = ? and conditions = ? and id != ?",
+			record.InfoType, record.MsgType, record.Event, record.Appids, record.Conditions, record.ID).
+		Count(&count)
+	return count != 0, result.Error
+}
+
+// GetWxCallBackRuleWithCache 按消息类型、appid和消息内容匹配优先级最高的转发规则 有缓存
+func GetWxCallBackRuleWithCache(appid string, infoType string, msgType string,
+	event string, msg map[string]interface{}) (*model.WxCallbackRule, error) {
+	rules, err := getAllWxCallBackRulesWithCache()
+	if err != nil {
+		return nil, err
 	}
-	log.Debug(record)
-	cacheCli := db.GetCache()
-	key := genCallBackRuleKey(record.InfoType, record.MsgType, record.Event)
-	cacheCli.Delete(key)
-	return nil
+	var hit *model.WxCallbackRule
+	for _, v := range rules {
+		// 优先级相同时取先创建的规则
+		if !v.Match(appid, infoType, msgType, event) || (hit != nil && v.Priority() <= hit.Priority()) {
+			continue
+		}
+		if err := v.CheckConditions(msg); err == nil {
+			hit = v
+		}
+	}
+	return hit, nil
 }
 
-// GetWxCallBackRuleWithCache 通过消息类型获取转发规则 有缓存
-func GetWxCallBackRuleWithCache(infoType string, msgType string, event string) (*model.WxCallbackRule, error) {
+func getAllWxCallBackRulesWithCache() ([]*model.WxCallbackRule, error) {
 	cacheCli := db.GetCache()
-	key := genCallBackRuleKey(infoType, msgType, event)
-	value, found := cacheCli.Get(key)
-	if found {
-		log.Infof("hit cache key:", key)
-		if value == nil {
-			return nil, nil
-		}
-		return value.(*model.WxCallbackRule), nil
-	} else {
-		result, err := getWxCallBackRule(infoType, msgType, event)
-		if err == gorm.ErrRecordNotFound {
-			log.Infof("empty record")
-			cacheCli.Set(key, nil, cache.DefaultExpiration)
-			return nil, nil
+	if value, found := cacheCli.Get(callbackRuleCacheKey); found {
+		return value.([]*model.WxCallbackRule), nil
+	}
+	var records = []*model.WxCallbackRule{}
+	cli := db.Get()
+	if result := cli.Table(callbackRuleTableName).Order("id").Find(&records); result.Error != nil {
+		log.Error(result.Error)
+		return nil, result.Error
+	}
+	for _, v := range records {
+		if err := v.ParseConditions(); err != nil {
+			log.Errorf("invalid rule conditions, id %d, %v", v.ID, err)
 		}
-		if err != nil {
-			return nil, err
+		if err := v.ParseTransform(); err != nil {
+			log.Errorf("invalid rule transform, id %d, %v", v.ID, err)
 		}
-		cacheCli.Set(key, result, cache.DefaultExpiration)
-		return result, nil
 	}
+	cacheCli.Set(callbackRuleCacheKey, records, cache.DefaultExpiration)
+	return records, nil
 }
 
 // GetWxCallBackRuleById 通过id获取转发规则
@@ -107,16 +250,3 @@ func GetWxCallBackRuleById(id int32) (*model.WxCallbackRule, error) {
 		Take(&record)
 	return record, result.Error
 }
-
-func getWxCallBackRule(infoType string, msgType string, event string) (*model.WxCallbackRule, error) {
-	var record *model.WxCallbackRule
-	cli := db.Get()
-	result := cli.Table(callbackRuleTableName).
-		Where("infotype = ? and msgtype = ? and event = ?", infoType, msgType, event).
-		Take(&record)
-	return record, result.Error
-}
-
-func genCallBackRuleKey(infoType string, msgType string, event string) string {
-	return fmt.Sprintf("cb_%s_%s_%s", infoType, msgType, event)
-}
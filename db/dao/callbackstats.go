@@ -0,0 +1,146 @@
+package dao
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+const statsTableName = "wxcallback_stats"
+const statsLockKey = "callback_stats_lock"
+const statsCursorKey = "callback_stats_cursor"
+
+// 汇总语句 同一小时重复汇总时覆盖之前的结果 参数为起止时间
+var statsAggregateSqls = []string{
+	"INSERT INTO `wxcallback_stats` (`stattime`, `appid`, `infotype`, `msgtype`, `event`, `ruleid`, " +
+		"`msgcount`, `dupcount`) " +
+		"SELECT DATE_FORMAT(`receivetime`, '%Y-%m-%d %H:00:00'), '', `infotype`, '', '', 0, COUNT(*), " +
+		"COALESCE(SUM(`dupcount`), 0) FROM `wxcallback_component` WHERE `receivetime` >= ? AND `receivetime` < ? " +
+		"GROUP BY 1, `infotype` " +
+		"ON DUPLICATE KEY UPDATE `msgcount` = VALUES(`msgcount`), `dupcount` = VALUES(`dupcount`)",
+	"INSERT INTO `wxcallback_stats` (`stattime`, `appid`, `infotype`, `msgtype`, `event`, `ruleid`, " +
+		"`msgcount`, `dupcount`) " +
+		"SELECT DATE_FORMAT(`receivetime`, '%Y-%m-%d %H:00:00'), `appid`, '', `msgtype`, `event`, 0, COUNT(*), " +
+		"COALESCE(SUM(`dupcount`), 0) FROM `wxcallback_biz` WHERE `receivetime` >= ? AND `receivetime` < ? " +
+		"GROUP BY 1, `appid`, `msgtype`, `event` " +
+		"ON DUPLICATE KEY UPDATE `msgcount` = VALUES(`msgcount`), `dupcount` = VALUES(`dupcount`)",
+	"INSERT INTO `wxcallback_stats` (`stattime`, `appid`, `infotype`, `msgtype`, `event`, `ruleid`, " +
+		"`delivercount`, `deliversucc`, `deliverfail`, `latencysum`, `latencymax`) " +
+		"SELECT DATE_FORMAT(`createtime`, '%Y-%m-%d %H:00:00'), `appid`, `infotype`, `msgtype`, `event`, `ruleid`, " +
+		"COUNT(*), SUM(`result` = 1), SUM(`result` != 1), SUM(`latency`), MAX(`latency`) " +
+		"FROM `wxcallback_delivery` WHERE `createtime` >= ? AND `createtime` < ? " +
+		"GROUP BY 1, `appid`, `infotype`, `msgtype`, `event`, `ruleid` " +
+		"ON DUPLICATE KEY UPDATE `delivercount` = VALUES(`delivercount`), `deliversucc` = VALUES(`deliversucc`), " +
+		"`deliverfail` = VALUES(`deliverfail`), `latencysum` = VALUES(`latencysum`), " +
+		"`latencymax` = VALUES(`latencymax`)",
+}
+
+// AggregateCallBackStats 按小时汇总[from, to)内的消息和转发记录 from需为整点
+func AggregateCallBackStats(from time.Time, to time.Time) error {
+	cli := db.Get()
+	for _, sql := range statsAggregateSqls {
+		if err := cli.Exec(sql, from, to).Error; err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// CallBackStatFilter 消息统计的筛选条件 为空的字段不筛选
+type CallBackStatFilter struct {
+	StartTime time.Time
+	EndTime   time.Time
+	Appid     string
+	InfoType  string
+	MsgType   string
+	Event     string
+	RuleID    int32
+}
+
+// GetCallBackStats 按时间粒度和分组维度获取统计的时间序列
+func GetCallBackStats(filter *CallBackStatFilter, granularity string,
+	groupBy []string) ([]*model.CallbackStatPoint, error) {
+	var points = []*model.CallbackStatPoint{}
+	timeField := "UNIX_TIMESTAMP(`stattime`) AS `time`"
+	if granularity == model.STATGRANULARITY_DAY {
+		timeField = "UNIX_TIMESTAMP(DATE(`stattime`)) AS `time`"
+	}
+	fields := []string{timeField}
+	groups := []string{"`time`"}
+	for _, v := range groupBy {
+		switch v {
+		case model.STATGROUP_APPID:
+			fields = append(fields, "`appid`")
+			groups = append(groups, "`appid`")
+		case model.STATGROUP_EVENT:
+			fields = append(fields, "`infotype`", "`msgtype`", "`event`")
+			groups = append(groups, "`infotype`", "`msgtype`", "`event`")
+		case model.STATGROUP_RULE:
+			fields = append(fields, "`ruleid`")
+			groups = append(groups, "`ruleid`")
+		}
+	}
+	fields = append(fields, "SUM(`msgcount`) AS `msgcount`", "SUM(`dupcount`) AS `dupcount`",
+		"SUM(`delivercount`) AS `delivercount`", "SUM(`deliversucc`) AS `deliversucc`",
+		"SUM(`deliverfail`) AS `deliverfail`",
+		"COALESCE(SUM(`latencysum`) DIV NULLIF(SUM(`delivercount`), 0), 0) AS `latencyavg`",
+		"MAX(`latencymax`) AS `latencymax`")
+
+	cli := db.Get()
+	query := cli.Table(statsTableName).Select(strings.Join(fields, ", ")).
+		Where("stattime >= ? AND stattime < ?", filter.StartTime, filter.EndTime)
+	if filter.Appid != "" {
+		query = query.Where("appid = ?", filter.Appid)
+	}
+	if filter.InfoType != "" {
+		query = query.Where("infotype = ?", filter.InfoType)
+	}
+	if filter.MsgType != "" {
+		query = query.Where("msgtype = ?", filter.MsgType)
+	}
+	if filter.Event != "" {
+		query = query.Where("event = ?", filter.Event)
+	}
+	if filter.RuleID != 0 {
+		query = query.Where("ruleid = ?", filter.RuleID)
+	}
+	result := query.Group(strings.Join(groups, ", ")).Order("`time`").Scan(&points)
+	return points, result.Error
+}
+
+func aggregateRecentCallBackStats(interval time.Duration) {
+	hostname, _ := os.Hostname()
+	if err := Lock(statsLockKey, hostname, interval/2); err != nil {
+		log.Debugf("callback stats skipped: %v", err)
+		return
+	}
+	// 从上次汇总的前一小时开始重新汇总 覆盖上次未满一小时的数据
+	now := time.Now()
+	from := now.Truncate(time.Hour).Add(-24 * time.Hour)
+	if cursor, err := time.Parse(time.RFC3339, GetCommKv(statsCursorKey, "")); err == nil {
+		from = cursor.Add(-time.Hour)
+	}
+	if err := AggregateCallBackStats(from, now); err != nil {
+		return
+	}
+	SetCommKv(statsCursorKey, now.Truncate(time.Hour).Format(time.RFC3339))
+}
+
+func startCallBackStatsTask() {
+	if config.StatsConf.AggregateInterval <= 0 {
+		log.Info("callback stats disabled")
+		return
+	}
+	interval := time.Duration(config.StatsConf.AggregateInterval) * time.Minute
+	aggregateRecentCallBackStats(interval)
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		aggregateRecentCallBackStats(interval)
+	}
+}
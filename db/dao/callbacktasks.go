@@ -0,0 +1,136 @@
+package dao
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"gorm.io/gorm"
+)
+
+const deliveryTaskTableName = "wxcallback_delivery_task"
+
+// AddCallBackDeliveryTasks 批量增加异步转发任务
+func AddCallBackDeliveryTasks(tasks []*model.WxCallbackDeliveryTask) error {
+	cli := db.Get()
+	if err := cli.Table(deliveryTaskTableName).Create(tasks).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetDueCallBackDeliveryTasks 获取已到投递时间的任务
+func GetDueCallBackDeliveryTasks(limit int) ([]*model.WxCallbackDeliveryTask, error) {
+	var records = []*model.WxCallbackDeliveryTask{}
+	cli := db.Get()
+	result := cli.Table(deliveryTaskTableName).
+		Where("status = ? and nextretrytime <= ?", model.DELIVERYTASK_PENDING, time.Now()).
+		Order("nextretrytime").Limit(limit).Find(&records)
+	return records, result.Error
+}
+
+// ClaimCallBackDeliveryTask 抢占任务 成功后在lease时间内其他实例不会再投递该任务
+// 以读取时的claimtoken作为版本号 抢占时换成新值 读取后被其他实例抢占过的任务会抢占失败
+func ClaimCallBackDeliveryTask(task *model.WxCallbackDeliveryTask, lease time.Duration) (bool, error) {
+	token, err := genClaimToken()
+	if err != nil {
+		return false, err
+	}
+	cli := db.Get()
+	nextRetryTime := time.Now().Add(lease)
+	result := cli.Table(deliveryTaskTableName).
+		Where("id = ? and status = ? and claimtoken = ?", task.ID, model.DELIVERYTASK_PENDING, task.ClaimToken).
+		Updates(map[string]interface{}{
+			"claimtoken":    token,
+			"nextretrytime": nextRetryTime,
+			"attempts":      gorm.Expr("attempts + ?", 1),
+		})
+	if result.Error != nil {
+		log.Error(result.Error)
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	task.ClaimToken = token
+	task.NextRetryTime = nextRetryTime
+	task.Attempts++
+	return true, nil
+}
+
+func genClaimToken() (string, error) {
+	value := make([]byte, 16)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
+
+// DelCallBackDeliveryTask 删除任务
+func DelCallBackDeliveryTask(id int64) error {
+	cli := db.Get()
+	if err := cli.Table(deliveryTaskTableName).Where("id = ?", id).
+		Delete(&model.WxCallbackDeliveryTask{}).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateCallBackDeliveryTaskRetry 投递失败 更新下次重试时间或转为死信
+func UpdateCallBackDeliveryTaskRetry(id int64, status int, nextRetryTime time.Time, lastError string) error {
+	cli := db.Get()
+	if err := cli.Table(deliveryTaskTableName).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":        status,
+			"nextretrytime": nextRetryTime,
+			"lasterror":     lastError,
+		}).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetCallBackDeliveryTaskList 获取异步转发任务
+func GetCallBackDeliveryTaskList(status int, ruleId int32, appid string,
+	offset int, limit int) ([]*model.WxCallbackDeliveryTask, int64, error) {
+	var records = []*model.WxCallbackDeliveryTask{}
+	cli := db.Get()
+	result := cli.Table(deliveryTaskTableName)
+	if status != 0 {
+		result = result.Where("status = ?", status)
+	}
+	if ruleId != 0 {
+		result = result.Where("ruleid = ?", ruleId)
+	}
+	if appid != "" {
+		result = result.Where("appid = ?", appid)
+	}
+	var count int64
+	result = result.Count(&count).Order("id desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
+
+// RedriveCallBackDeadTasks 死信重新投递 ids为空时重新投递全部死信
+func RedriveCallBackDeadTasks(ids []int64) (int64, error) {
+	cli := db.Get()
+	result := cli.Table(deliveryTaskTableName).Where("status = ?", model.DELIVERYTASK_DEAD)
+	if len(ids) != 0 {
+		result = result.Where("id in ?", ids)
+	}
+	result = result.Updates(map[string]interface{}{
+		"status":        model.DELIVERYTASK_PENDING,
+		"attempts":      0,
+		"nextretrytime": time.Now(),
+	})
+	if result.Error != nil {
+		log.Error(result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
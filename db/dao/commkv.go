@@ -2,6 +2,7 @@ package dao
 
 import (
 	"encoding/base64"
+	"strings"
 	"time"
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/encrypt"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -41,6 +43,11 @@ func GetCommKvWithCache(key string, defaultValue string, d time.Duration) string
 
 // SetCommKv 覆盖写
 func SetCommKv(key string, value string) error {
+	return SetCommKvWithTx(db.Get(), key, value)
+}
+
+// SetCommKvWithTx 在事务中覆盖写
+func SetCommKvWithTx(tx *gorm.DB, key string, value string) error {
 	log.Infof("SetCommKv: %s %s", key, value)
 	var err error
 	var kv = model.CommKv{
@@ -48,8 +55,7 @@ func SetCommKv(key string, value string) error {
 		Value: value,
 	}
 
-	cli := db.Get()
-	err = cli.Table(commTableName).Clauses(clause.OnConflict{
+	err = tx.Table(commTableName).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "key"}},
 		DoUpdates: clause.AssignmentColumns([]string{"value"}),
 	}).Create(kv).Error
@@ -102,6 +108,19 @@ func DelExpiredCommKv(key string, d time.Duration) (int64, error) {
 	return result.RowsAffected, nil
 }
 
+// DelExpiredCommKvWithPrefix 删除key以prefix开头的超时记录
+func DelExpiredCommKvWithPrefix(prefix string, d time.Duration) (int64, error) {
+	cli := db.Get()
+	result := cli.Table(commTableName).
+		Where("`key` like ? and UpdateTime < ?", strings.Replace(prefix, "_", "\\_", -1)+"%",
+			time.Now().Add(-d)).Delete(&model.CommKv{})
+	if result.Error != nil {
+		log.Error(result.Error)
+		return result.RowsAffected, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
 // GetCommKv 读
 func GetCommKv(key string, defaultValue string) string {
 	var err error
@@ -162,3 +181,14 @@ func Lock(key string, value string, expire time.Duration) error {
 func UnLock(key string) error {
 	return DelCommKv(key)
 }
+
+// UnLockWithValue 释放自己持有的锁 锁已过期并被其他实例抢占时不删除
+func UnLockWithValue(key string, value string) error {
+	cli := db.Get()
+	if err := cli.Table(commTableName).Where("`key` = ? and `value` = ?", key, value).
+		Delete(&model.CommKv{}).Error; err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
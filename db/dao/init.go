@@ -3,5 +3,7 @@ package dao
 // Init 初始化
 func Init() error {
 	go startClearExpiredRecordTask()
+	go startRetentionPurgeTask()
+	go startCallBackStatsTask()
 	return nil
 }
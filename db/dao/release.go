@@ -0,0 +1,106 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const releaseStateTableName = "wxweapp_release"
+const releaseHistoryTableName = "wxweapp_release_history"
+
+// GetReleaseState 获取授权账号的发布状态 没有记录时返回初始状态
+func GetReleaseState(appid string) (*model.WxReleaseState, error) {
+	var record = model.WxReleaseState{}
+	cli := db.Get()
+	result := cli.Table(releaseStateTableName).Where("appid = ?", appid).Take(&record)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return &model.WxReleaseState{Appid: appid}, nil
+		}
+		log.Error(result.Error)
+		return nil, result.Error
+	}
+	return &record, nil
+}
+
+// ErrReleaseTransition 不允许的发布状态变更
+var ErrReleaseTransition = errors.New("release state transition not allowed")
+
+// TransitReleaseState 变更发布状态并记录变更历史 update用于同时修改版本号等字段
+// 不允许的变更返回ErrReleaseTransition 状态保持不变
+func TransitReleaseState(appid string, toState int, source string, reason string,
+	update func(*model.WxReleaseState)) (*model.WxReleaseState, error) {
+	var record model.WxReleaseState
+	cli := db.Get()
+	err := cli.Transaction(func(tx *gorm.DB) error {
+		// 审核事件和管理端操作可能并发 锁住当前状态再变更
+		err := tx.Table(releaseStateTableName).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("appid = ?", appid).Take(&record).Error
+		if err == gorm.ErrRecordNotFound {
+			record = model.WxReleaseState{Appid: appid}
+		} else if err != nil {
+			return err
+		}
+		if !model.CanTransitRelease(record.State, toState, source) {
+			return fmt.Errorf("%w: %d -> %d, source %s", ErrReleaseTransition, record.State, toState, source)
+		}
+		history := model.WxReleaseHistory{
+			Appid:     appid,
+			FromState: record.State,
+			ToState:   toState,
+			Source:    source,
+			Reason:    reason,
+		}
+		record.State = toState
+		record.Reason = reason
+		if update != nil {
+			update(&record)
+		}
+		if err := tx.Table(releaseStateTableName).Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"state", "userversion", "auditid", "autorelease",
+				"reason"}),
+		}).Omit("id").Create(&record).Error; err != nil {
+			return err
+		}
+		history.UserVersion = record.UserVersion
+		history.AuditId = record.AuditId
+		return tx.Table(releaseHistoryTableName).Create(&history).Error
+	})
+	if errors.Is(err, ErrReleaseTransition) {
+		log.Infof("appid %s: %v", appid, err)
+		return nil, err
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SetReleaseAutoRelease 设置审核通过后是否自动发布
+func SetReleaseAutoRelease(appid string, autoRelease int) error {
+	cli := db.Get()
+	record := model.WxReleaseState{Appid: appid, AutoRelease: autoRelease}
+	if err := cli.Table(releaseStateTableName).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"autorelease"}),
+	}).Omit("id").Create(&record).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetReleaseHistory 获取发布状态的变更历史 按时间倒序
+func GetReleaseHistory(appid string, offset int, limit int) ([]*model.WxReleaseHistory, int64, error) {
+	var records = []*model.WxReleaseHistory{}
+	cli := db.Get()
+	var count int64
+	result := cli.Table(releaseHistoryTableName).Where("appid = ?", appid).Count(&count).
+		Order("id desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
@@ -0,0 +1,157 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+const retentionLockKey = "retention_purge_lock"
+const retentionResultKey = "retention_last_purge"
+
+// GetRetentionPolicies 获取各消息记录表的保留策略
+func GetRetentionPolicies() []model.RetentionPolicy {
+	conf := config.RetentionConf
+	return []model.RetentionPolicy{
+		{Table: componentTableName, TimeColumn: "receivetime", MaxDays: conf.ComponentMaxDays,
+			MaxRows: conf.ComponentMaxRows},
+		{Table: bizTableName, TimeColumn: "receivetime", MaxDays: conf.BizMaxDays, MaxRows: conf.BizMaxRows},
+		{Table: deliveryTableName, TimeColumn: "createtime", MaxDays: conf.DeliveryMaxDays,
+			MaxRows: conf.DeliveryMaxRows},
+		{Table: deliveryTaskTableName, TimeColumn: "updatetime", MaxDays: conf.DeadTaskMaxDays,
+			MaxRows: conf.DeadTaskMaxRows, Condition: fmt.Sprintf("`status` = %d", model.DELIVERYTASK_DEAD)},
+		{Table: statsTableName, TimeColumn: "stattime", MaxDays: conf.StatsMaxDays, MaxRows: conf.StatsMaxRows},
+		{Table: callbackRuleHistoryTableName, TimeColumn: "createtime", MaxDays: conf.RuleHistoryMaxDays,
+			MaxRows: conf.RuleHistoryMaxRows},
+	}
+}
+
+// GetLastRetentionPurge 获取最近一次清理的结果 没有清理过时返回nil
+func GetLastRetentionPurge() *model.RetentionPurgeResult {
+	value := GetCommKv(retentionResultKey, "")
+	if value == "" {
+		return nil
+	}
+	var result model.RetentionPurgeResult
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return &result
+}
+
+// PurgeCallBackRecords 按保留策略分批删除过期的消息记录
+func PurgeCallBackRecords(policy *model.RetentionPolicy) (int64, error) {
+	var total int64
+	condition := ""
+	if policy.Condition != "" {
+		condition = " AND " + policy.Condition
+	}
+	if policy.MaxDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -policy.MaxDays)
+		deleted, err := purgeInBatches(policy.Table, fmt.Sprintf("`%s` < ?%s", policy.TimeColumn, condition), cutoff)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	if policy.MaxRows > 0 {
+		// 保留id最大的MaxRows行 删除第MaxRows+1行及更早的记录
+		var ids []int64
+		cli := db.Get()
+		query := cli.Table(policy.Table)
+		if policy.Condition != "" {
+			query = query.Where(policy.Condition)
+		}
+		if err := query.Order("id desc").Offset(int(policy.MaxRows)).Limit(1).
+			Pluck("id", &ids).Error; err != nil {
+			log.Error(err)
+			return total, err
+		}
+		if len(ids) != 0 {
+			deleted, err := purgeInBatches(policy.Table, "`id` <= ?"+condition, ids[0])
+			total += deleted
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// purgeInBatches 每次按id顺序删除一批 批次之间停顿以免长时间占用表锁
+func purgeInBatches(table string, where string, arg interface{}) (int64, error) {
+	batchSize := config.RetentionConf.PurgeBatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	pause := time.Duration(config.RetentionConf.PurgeBatchPause) * time.Millisecond
+	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s ORDER BY `id` LIMIT ?", table, where)
+	var total int64
+	cli := db.Get()
+	for {
+		result := cli.Exec(sql, arg, batchSize)
+		if result.Error != nil {
+			log.Error(result.Error)
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return total, nil
+		}
+		time.Sleep(pause)
+	}
+}
+
+func purgeExpiredCallBackRecords(interval time.Duration) {
+	// 多实例部署时同一时间只由一个实例清理 实例异常退出时锁在过期后可重新抢占
+	hostname, _ := os.Hostname()
+	if err := Lock(retentionLockKey, hostname, interval/2); err != nil {
+		log.Debugf("retention purge skipped: %v", err)
+		return
+	}
+	defer UnLockWithValue(retentionLockKey, hostname)
+	result := model.RetentionPurgeResult{StartTime: time.Now().Unix()}
+	for _, policy := range GetRetentionPolicies() {
+		if policy.MaxDays <= 0 && policy.MaxRows <= 0 {
+			continue
+		}
+		deleted, err := PurgeCallBackRecords(&policy)
+		tableResult := model.RetentionTableResult{Table: policy.Table, Deleted: deleted}
+		if err != nil {
+			tableResult.ErrMsg = err.Error()
+		}
+		log.Infof("retention purge %s deleted %d", policy.Table, deleted)
+		result.Tables = append(result.Tables, tableResult)
+	}
+	if config.WxCallbackConf.DedupWindow > 0 {
+		since := time.Now().Add(-time.Duration(config.WxCallbackConf.DedupWindow) * time.Second)
+		deleted, err := PurgeCallBackDedupKeys(since)
+		tableResult := model.RetentionTableResult{Table: dedupTableName, Deleted: deleted}
+		if err != nil {
+			tableResult.ErrMsg = err.Error()
+		}
+		result.Tables = append(result.Tables, tableResult)
+	}
+	result.EndTime = time.Now().Unix()
+	value, _ := json.Marshal(result)
+	SetCommKv(retentionResultKey, string(value))
+}
+
+func startRetentionPurgeTask() {
+	if config.RetentionConf.PurgeInterval <= 0 {
+		log.Info("retention purge disabled")
+		return
+	}
+	interval := time.Duration(config.RetentionConf.PurgeInterval) * time.Minute
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		purgeExpiredCallBackRecords(interval)
+	}
+}
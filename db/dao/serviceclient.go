@@ -0,0 +1,152 @@
+package dao
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/encrypt"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+const serviceClientTableName = "service_clients"
+const serviceClientCacheKey = "service_clients"
+
+// ErrServiceClientNotFound 修改、重置密钥或删除的客户端不存在
+var ErrServiceClientNotFound = errors.New("service client not found")
+
+// GetServiceClientList 获取内部服务客户端 不包含密钥
+func GetServiceClientList(offset int, limit int) ([]*model.ServiceClient, int64, error) {
+	var records = []*model.ServiceClient{}
+	cli := db.Get()
+	result := cli.Table(serviceClientTableName).Omit("secret")
+	var count int64
+	result = result.Count(&count).Order("id").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
+
+// AddServiceClient 添加客户端 密钥加密后写入
+func AddServiceClient(record *model.ServiceClient) error {
+	value := *record
+	var err error
+	if value.Secret, err = encryptServiceClientSecret(record.Secret); err != nil {
+		return err
+	}
+	cli := db.Get()
+	if result := cli.Table(serviceClientTableName).Create(&value); result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	record.ID = value.ID
+	db.GetCache().Delete(serviceClientCacheKey)
+	return nil
+}
+
+// UpdateServiceClient 更新客户端的权限 不改变名称和密钥 客户端不存在时返回ErrServiceClientNotFound
+func UpdateServiceClient(record *model.ServiceClient) error {
+	cli := db.Get()
+	result := cli.Table(serviceClientTableName).
+		Where("id = ?", record.ID).
+		Select("appids", "tokentypes", "open", "expiretime").
+		Updates(record)
+	if result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 内容未变化时影响行数也为0
+		var count int64
+		if err := cli.Table(serviceClientTableName).Where("id = ?", record.ID).Count(&count).Error; err != nil {
+			log.Error(err)
+			return err
+		}
+		if count == 0 {
+			return ErrServiceClientNotFound
+		}
+	}
+	db.GetCache().Delete(serviceClientCacheKey)
+	return nil
+}
+
+// UpdateServiceClientSecret 重置客户端的密钥 客户端不存在时返回ErrServiceClientNotFound
+func UpdateServiceClientSecret(id int32, secret string) error {
+	value, err := encryptServiceClientSecret(secret)
+	if err != nil {
+		return err
+	}
+	cli := db.Get()
+	result := cli.Table(serviceClientTableName).Where("id = ?", id).Update("secret", value)
+	if result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrServiceClientNotFound
+	}
+	db.GetCache().Delete(serviceClientCacheKey)
+	return nil
+}
+
+// DelServiceClient 删除客户端 客户端不存在时返回ErrServiceClientNotFound
+func DelServiceClient(id int32) error {
+	cli := db.Get()
+	result := cli.Table(serviceClientTableName).Where("id = ?", id).Delete(&model.ServiceClient{})
+	if result.Error != nil {
+		log.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrServiceClientNotFound
+	}
+	db.GetCache().Delete(serviceClientCacheKey)
+	return nil
+}
+
+// GetServiceClientWithCache 按名称获取客户端 密钥为明文 有缓存 不存在时返回nil
+func GetServiceClientWithCache(name string) (*model.ServiceClient, error) {
+	cacheCli := db.GetCache()
+	if value, found := cacheCli.Get(serviceClientCacheKey); found {
+		return value.(map[string]*model.ServiceClient)[name], nil
+	}
+	var records []*model.ServiceClient
+	cli := db.Get()
+	if result := cli.Table(serviceClientTableName).Find(&records); result.Error != nil {
+		log.Error(result.Error)
+		return nil, result.Error
+	}
+	clients := make(map[string]*model.ServiceClient, len(records))
+	for _, v := range records {
+		secret, err := decryptServiceClientSecret(v.Secret)
+		if err != nil {
+			log.Errorf("decrypt secret of service client %s err %v", v.Name, err)
+			continue
+		}
+		v.Secret = secret
+		clients[v.Name] = v
+	}
+	cacheCli.Set(serviceClientCacheKey, clients, time.Minute)
+	return clients[name], nil
+}
+
+func encryptServiceClientSecret(secret string) (string, error) {
+	value, err := encrypt.AesEncrypt([]byte(secret), []byte(config.ServerConf.AesKey))
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(value), nil
+}
+
+func decryptServiceClientSecret(secret string) (string, error) {
+	value, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	if value, err = encrypt.AesDecrypt(value, []byte(config.ServerConf.AesKey)); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
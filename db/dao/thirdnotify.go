@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"gorm.io/gorm/clause"
+)
+
+const thirdNotifyTableName = "wxthird_notify"
+
+// CreateOrUpdateThirdNotify 记录第三方平台通知的处理结果 infotype和uniqkey相同时更新
+func CreateOrUpdateThirdNotify(record *model.WxThirdNotify) error {
+	cli := db.Get()
+	if err := cli.Table(thirdNotifyTableName).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"appid", "status", "msg", "info"}),
+	}).Create(record).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetThirdNotifyList 获取第三方平台通知的处理结果
+func GetThirdNotifyList(infoType string, appid string, offset int, limit int) ([]*model.WxThirdNotify, int64, error) {
+	var records = []*model.WxThirdNotify{}
+	cli := db.Get()
+	result := cli.Table(thirdNotifyTableName)
+	if infoType != "" {
+		result = result.Where("infotype = ?", infoType)
+	}
+	if appid != "" {
+		result = result.Where("appid = ?", appid)
+	}
+	var count int64
+	result = result.Count(&count).Order("updatetime desc").Offset(offset).Limit(limit).Find(&records)
+	return records, count, result.Error
+}
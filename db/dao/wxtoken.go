@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
 	"gorm.io/gorm"
@@ -25,6 +26,29 @@ func GetAccessToken(appid string, tokenType int) (*model.WxToken, bool, error) {
 	}
 }
 
+// GetAccessTokenList 获取所有token的过期时间 不包含token内容
+func GetAccessTokenList() ([]*model.WxToken, error) {
+	cli := db.Get()
+	var records []*model.WxToken
+	if err := cli.Table(wxTokenTableName).Select("type", "appid", "expiretime", "updatetime").
+		Order("type desc, appid").Find(&records).Error; err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return records, nil
+}
+
+// DelAccessToken 删除无效的token 只删除内容相同的记录 避免删除其他实例刚刷新的token
+func DelAccessToken(appid string, tokenType int, token string) error {
+	cli := db.Get()
+	if err := cli.Table(wxTokenTableName).Where("appid = ? and type = ? and token = ?", appid, tokenType, token).
+		Delete(&model.WxToken{}).Error; err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
 // SetAccessToken 创建或更新wxtoken
 func SetAccessToken(record *model.WxToken) error {
 	cli := db.Get()
@@ -67,15 +67,71 @@ func Init() error {
 }
 
 func checkTables() {
-	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_component` (`id` INT UNSIGNED AUTO_INCREMENT, `receivetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `infotype` VARCHAR(64) NOT NULL DEFAULT '', `postbody` TEXT NOT NULL, PRIMARY KEY (`id`), INDEX(`receivetime`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
-	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_biz` (`id` INT UNSIGNED AUTO_INCREMENT, `receivetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `tousername` VARCHAR(64) NOT NULL DEFAULT '', `appid` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `postbody` TEXT NOT NULL, PRIMARY KEY (`id`), INDEX(`receivetime`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_component` (`id` INT UNSIGNED AUTO_INCREMENT, `receivetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `infotype` VARCHAR(64) NOT NULL DEFAULT '', `postbody` TEXT NOT NULL, `dedupkey` VARCHAR(256) NOT NULL DEFAULT '', `dupcount` INT NOT NULL DEFAULT 0, PRIMARY KEY (`id`), INDEX(`receivetime`), INDEX(`dedupkey`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_biz` (`id` INT UNSIGNED AUTO_INCREMENT, `receivetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `tousername` VARCHAR(64) NOT NULL DEFAULT '', `appid` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `fromusername` VARCHAR(128) NOT NULL DEFAULT '', `postbody` TEXT NOT NULL, `dedupkey` VARCHAR(256) NOT NULL DEFAULT '', `dupcount` INT NOT NULL DEFAULT 0, PRIMARY KEY (`id`), INDEX(`receivetime`), INDEX(`dedupkey`), INDEX(`fromusername`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_dedup` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `dedupkey` VARCHAR(255) NOT NULL, `receivetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`dedupkey`), INDEX(`receivetime`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
 	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `comm` (`key` VARCHAR(64) NOT NULL, `value` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`key`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
 	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `user` ( `id` INT NOT NULL AUTO_INCREMENT, `username` VARCHAR(32) NOT NULL, `password` VARCHAR(64) NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`ID`), UNIQUE KEY `user_username_uindex` (`username`) ) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
-	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `authorizers` ( `id` INT NOT NULL AUTO_INCREMENT, `appid` VARCHAR(32) NOT NULL, `apptype` INT NOT NULL DEFAULT 0, `servicetype` INT NOT NULL DEFAULT 0, `nickname` VARCHAR(32) NOT NULL NOT NULL DEFAULT '', `username` VARCHAR(32) NOT NULL NOT NULL DEFAULT '', `headimg` VARCHAR(256) NOT NULL DEFAULT '', `qrcodeurl` VARCHAR(256) NOT NULL DEFAULT '',`principalname` VARCHAR(64) NOT NULL DEFAULT '', `refreshtoken` VARCHAR(128) NOT NULL DEFAULT '', `funcinfo` VARCHAR(128) NOT NULL DEFAULT '', `verifyinfo` INT NOT NULL DEFAULT -1, `authtime` TIMESTAMP NOT NULL, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
-	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_rules` (`id` INT UNSIGNED AUTO_INCREMENT, `name` VARCHAR(64) NOT NULL DEFAULT '', `infotype` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `type` INT NOT NULL DEFAULT 0, `open` INT NOT NULL DEFAULT 0,  `info` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(infotype, msgtype, event)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `authorizers` ( `id` INT NOT NULL AUTO_INCREMENT, `appid` VARCHAR(32) NOT NULL, `apptype` INT NOT NULL DEFAULT 0, `servicetype` INT NOT NULL DEFAULT 0, `nickname` VARCHAR(32) NOT NULL NOT NULL DEFAULT '', `username` VARCHAR(32) NOT NULL NOT NULL DEFAULT '', `headimg` VARCHAR(256) NOT NULL DEFAULT '', `qrcodeurl` VARCHAR(256) NOT NULL DEFAULT '',`principalname` VARCHAR(64) NOT NULL DEFAULT '', `refreshtoken` VARCHAR(128) NOT NULL DEFAULT '', `funcinfo` VARCHAR(128) NOT NULL DEFAULT '', `verifyinfo` INT NOT NULL DEFAULT -1, `authtime` TIMESTAMP NOT NULL, `health` INT NOT NULL DEFAULT 0, `healthmsg` VARCHAR(256) NOT NULL DEFAULT '', `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_rules` (`id` INT UNSIGNED AUTO_INCREMENT, `name` VARCHAR(64) NOT NULL DEFAULT '', `infotype` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `appids` VARCHAR(2048) NOT NULL DEFAULT '', `type` INT NOT NULL DEFAULT 0, `open` INT NOT NULL DEFAULT 0, `async` INT NOT NULL DEFAULT 0, `info` TEXT NOT NULL, `conditions` VARCHAR(4096) NOT NULL DEFAULT '', `transform` TEXT NOT NULL, `replydeadline` INT NOT NULL DEFAULT 0, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), INDEX(infotype, msgtype, event)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
 	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxtoken` (`id` INT UNSIGNED AUTO_INCREMENT, `type` INT NOT NULL DEFAULT 0, `appid` VARCHAR(128) NOT NULL DEFAULT '', `token` TEXT NOT NULL, `expiretime` TIMESTAMP NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY `appid_uindex` (`appid`) ) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
 	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `counter` (`id` INT UNSIGNED AUTO_INCREMENT, `key` VARCHAR(64) NOT NULL, `value` INT UNSIGNED, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`key`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
 	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `counter` (`id` INT UNSIGNED AUTO_INCREMENT, `key` VARCHAR(64) NOT NULL, `value` INT UNSIGNED, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`key`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_delivery` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `ruleid` INT UNSIGNED NOT NULL DEFAULT 0, `appid` VARCHAR(64) NOT NULL DEFAULT '', `infotype` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `target` VARCHAR(512) NOT NULL DEFAULT '', `isprimary` INT NOT NULL DEFAULT 0, `statuscode` INT NOT NULL DEFAULT 0, `latency` INT NOT NULL DEFAULT 0, `result` INT NOT NULL DEFAULT 0, `errmsg` TEXT NOT NULL, `replayid` BIGINT NOT NULL DEFAULT 0, `late` INT NOT NULL DEFAULT 0, `response` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), INDEX(`createtime`), INDEX(`ruleid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_delivery_task` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `ruleid` INT UNSIGNED NOT NULL DEFAULT 0, `targetindex` INT NOT NULL DEFAULT 0, `appid` VARCHAR(64) NOT NULL DEFAULT '', `infotype` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `header` TEXT NOT NULL, `rawquery` TEXT NOT NULL, `postbody` TEXT NOT NULL, `status` INT NOT NULL DEFAULT 0, `attempts` INT NOT NULL DEFAULT 0, `nextretrytime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `lasterror` TEXT NOT NULL, `claimtoken` VARCHAR(64) NOT NULL DEFAULT '', `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), INDEX(`status`, `nextretrytime`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_autoreply` (`id` INT UNSIGNED AUTO_INCREMENT, `name` VARCHAR(64) NOT NULL DEFAULT '', `appid` VARCHAR(64) NOT NULL DEFAULT '', `type` INT NOT NULL DEFAULT 0, `matchtype` INT NOT NULL DEFAULT 0, `keyword` VARCHAR(256) NOT NULL DEFAULT '', `replytype` INT NOT NULL DEFAULT 0, `info` TEXT NOT NULL, `open` INT NOT NULL DEFAULT 0, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), INDEX(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxthird_notify` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `infotype` VARCHAR(64) NOT NULL DEFAULT '', `appid` VARCHAR(64) NOT NULL DEFAULT '', `uniqkey` VARCHAR(256) NOT NULL DEFAULT '', `status` INT NOT NULL DEFAULT 0, `msg` TEXT NOT NULL, `info` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`infotype`, `uniqkey`), INDEX(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxweapp_release` (`id` INT UNSIGNED AUTO_INCREMENT, `appid` VARCHAR(64) NOT NULL DEFAULT '', `state` INT NOT NULL DEFAULT 0, `userversion` VARCHAR(64) NOT NULL DEFAULT '', `auditid` BIGINT NOT NULL DEFAULT 0, `autorelease` INT NOT NULL DEFAULT 0, `reason` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxweapp_release_history` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `appid` VARCHAR(64) NOT NULL DEFAULT '', `fromstate` INT NOT NULL DEFAULT 0, `tostate` INT NOT NULL DEFAULT 0, `userversion` VARCHAR(64) NOT NULL DEFAULT '', `auditid` BIGINT NOT NULL DEFAULT 0, `source` VARCHAR(32) NOT NULL DEFAULT '', `reason` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), INDEX(`appid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_stats` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `stattime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `appid` VARCHAR(64) NOT NULL DEFAULT '', `infotype` VARCHAR(64) NOT NULL DEFAULT '', `msgtype` VARCHAR(64) NOT NULL DEFAULT '', `event` VARCHAR(64) NOT NULL DEFAULT '', `ruleid` INT UNSIGNED NOT NULL DEFAULT 0, `msgcount` INT NOT NULL DEFAULT 0, `dupcount` INT NOT NULL DEFAULT 0, `delivercount` INT NOT NULL DEFAULT 0, `deliversucc` INT NOT NULL DEFAULT 0, `deliverfail` INT NOT NULL DEFAULT 0, `latencysum` BIGINT NOT NULL DEFAULT 0, `latencymax` INT NOT NULL DEFAULT 0, PRIMARY KEY (`id`), UNIQUE KEY(`stattime`, `appid`, `infotype`, `msgtype`, `event`, `ruleid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `wxcallback_rules_history` (`id` BIGINT UNSIGNED AUTO_INCREMENT, `ruleid` INT UNSIGNED NOT NULL DEFAULT 0, `version` INT NOT NULL DEFAULT 0, `action` VARCHAR(32) NOT NULL DEFAULT '', `operator` VARCHAR(32) NOT NULL DEFAULT '', `fromversion` INT NOT NULL DEFAULT 0, `beforerule` TEXT NOT NULL, `afterrule` TEXT NOT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`ruleid`, `version`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	dbInstance.Exec("CREATE TABLE IF NOT EXISTS `service_clients` (`id` INT UNSIGNED AUTO_INCREMENT, `name` VARCHAR(64) NOT NULL, `secret` VARCHAR(256) NOT NULL DEFAULT '', `appids` VARCHAR(2048) NOT NULL DEFAULT '', `tokentypes` VARCHAR(64) NOT NULL DEFAULT '', `open` INT NOT NULL DEFAULT 1, `expiretime` TIMESTAMP NULL DEFAULT NULL, `createtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, `updatetime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, PRIMARY KEY (`id`), UNIQUE KEY(`name`)) ENGINE=InnoDB DEFAULT CHARSET=utf8;")
+	addColumnIfNotExists("authorizers", "health", "INT NOT NULL DEFAULT 0")
+	addColumnIfNotExists("authorizers", "healthmsg", "VARCHAR(256) NOT NULL DEFAULT ''")
+	addColumnIfNotExists("wxcallback_rules", "async", "INT NOT NULL DEFAULT 0")
+	addColumnIfNotExists("wxcallback_rules", "appids", "VARCHAR(2048) NOT NULL DEFAULT ''")
+	addColumnIfNotExists("wxcallback_rules", "conditions", "VARCHAR(4096) NOT NULL DEFAULT ''")
+	addColumnIfNotExists("wxcallback_rules", "transform", "TEXT NOT NULL")
+	addColumnIfNotExists("wxcallback_rules", "replydeadline", "INT NOT NULL DEFAULT 0")
+	addColumnIfNotExists("wxcallback_delivery_task", "claimtoken", "VARCHAR(64) NOT NULL DEFAULT ''")
+	addColumnIfNotExists("wxcallback_delivery", "replayid", "BIGINT NOT NULL DEFAULT 0")
+	addColumnIfNotExists("wxcallback_delivery", "late", "INT NOT NULL DEFAULT 0")
+	addColumnIfNotExists("wxcallback_delivery", "response", "TEXT NOT NULL")
+	for _, table := range []string{"wxcallback_component", "wxcallback_biz"} {
+		if addColumnIfNotExists(table, "dedupkey", "VARCHAR(256) NOT NULL DEFAULT ''") {
+			dbInstance.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD INDEX(`dedupkey`);", table))
+		}
+		addColumnIfNotExists(table, "dupcount", "INT NOT NULL DEFAULT 0")
+	}
+	if addColumnIfNotExists("wxcallback_biz", "fromusername", "VARCHAR(128) NOT NULL DEFAULT ''") {
+		dbInstance.Exec("ALTER TABLE `wxcallback_biz` ADD INDEX(`fromusername`);")
+	}
+	// 同一消息类型允许按appid配置多条规则 旧表的唯一索引改为普通索引
+	if dropIndexIfExists("wxcallback_rules", "infotype") {
+		dbInstance.Exec("ALTER TABLE `wxcallback_rules` ADD INDEX(infotype, msgtype, event);")
+	}
+}
+
+// dropIndexIfExists 删除已存在的唯一索引 返回是否删除
+func dropIndexIfExists(table string, index string) bool {
+	var count int64
+	dbInstance.Raw("SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ? AND NON_UNIQUE = 0",
+		table, index).Scan(&count)
+	if count == 0 {
+		return false
+	}
+	return dbInstance.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`;", table, index)).Error == nil
+}
+
+// addColumnIfNotExists 给已存在的表补充新增的字段 返回是否新增
+func addColumnIfNotExists(table string, column string, definition string) bool {
+	var count int64
+	dbInstance.Raw("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		table, column).Scan(&count)
+	if count != 0 {
+		return false
+	}
+	return dbInstance.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s;", table, column, definition)).Error == nil
 }
 
 // Get
@@ -17,4 +17,19 @@ type Authorizer struct {
 	FuncInfo      string    `gorm:"column:funcinfo" json:"funcInfo"`
 	VerifyInfo    int       `gorm:"column:verifyinfo" json:"verifyInfo"`
 	AuthTime      time.Time `gorm:"column:authtime" json:"authTime"`
+	Health        int       `gorm:"column:health" json:"health"`       // 授权状态
+	HealthMsg     string    `gorm:"column:healthmsg" json:"healthMsg"` // 状态异常的原因
 }
+
+// 授权账号类型
+const (
+	APPTYPE_WEAPP = 0 // 小程序
+	APPTYPE_MP    = 1 // 公众号
+)
+
+// 授权状态 刷新token的结果和授权变更事件会更新状态 重新授权后恢复正常
+const (
+	AUTHORIZERHEALTH_OK           = 0 // 正常
+	AUTHORIZERHEALTH_REFRESHFAIL  = 1 // 刷新token失败
+	AUTHORIZERHEALTH_UNAUTHORIZED = 2 // 已取消授权或refreshtoken已失效 需重新授权
+)
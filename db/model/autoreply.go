@@ -0,0 +1,82 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WxAutoReplyRule 公众号自动回复规则
+type WxAutoReplyRule struct {
+	ID         int32     `gorm:"column:id;primaryKey" json:"id"`
+	Name       string    `gorm:"column:name" json:"name"`
+	Appid      string    `gorm:"column:appid" json:"appid"` // 为空时对所有授权账号生效
+	Type       int       `gorm:"column:type" json:"type"`
+	MatchType  int       `gorm:"column:matchtype" json:"matchType"`
+	Keyword    string    `gorm:"column:keyword" json:"keyword"`
+	ReplyType  int       `gorm:"column:replytype" json:"replyType"`
+	Info       string    `gorm:"column:info" json:"info"`
+	Open       int       `gorm:"column:open" json:"open"`
+	CreateTime time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime time.Time `gorm:"column:updatetime;default:null" json:"updateTime"`
+
+	regex *regexp.Regexp
+}
+
+// AutoReplyInfo 回复内容 按回复类型填写对应字段
+type AutoReplyInfo struct {
+	Content  string             `json:"content,omitempty"`
+	MediaId  string             `json:"mediaId,omitempty"`
+	Articles []AutoReplyArticle `json:"articles,omitempty"`
+}
+
+// AutoReplyArticle 图文消息
+type AutoReplyArticle struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	PicUrl      string `json:"picUrl"`
+	Url         string `json:"url"`
+}
+
+// 自动回复规则类型
+const AUTOREPLYTYPE_KEYWORD = 1
+const AUTOREPLYTYPE_SUBSCRIBE = 2
+
+// 关键词匹配方式
+const AUTOREPLYMATCH_EXACT = 1
+const AUTOREPLYMATCH_PREFIX = 2
+const AUTOREPLYMATCH_REGEX = 3
+
+// 回复类型
+const AUTOREPLY_TEXT = 1
+const AUTOREPLY_IMAGE = 2
+const AUTOREPLY_NEWS = 3
+const AUTOREPLY_TRANSFER = 4
+
+// Compile 预编译正则 规则放入缓存前调用
+func (r *WxAutoReplyRule) Compile() error {
+	r.regex = nil
+	if r.MatchType != AUTOREPLYMATCH_REGEX {
+		return nil
+	}
+	regex, err := regexp.Compile(r.Keyword)
+	if err != nil {
+		return err
+	}
+	r.regex = regex
+	return nil
+}
+
+// MatchKeyword 文本消息是否命中关键词
+func (r *WxAutoReplyRule) MatchKeyword(content string) bool {
+	content = strings.TrimSpace(content)
+	switch r.MatchType {
+	case AUTOREPLYMATCH_EXACT:
+		return content == r.Keyword
+	case AUTOREPLYMATCH_PREFIX:
+		return strings.HasPrefix(content, r.Keyword)
+	case AUTOREPLYMATCH_REGEX:
+		return r.regex != nil && r.regex.MatchString(content)
+	}
+	return false
+}
@@ -7,23 +7,77 @@ import (
 
 // WxCallbackComponentRecord 第三方授权事件的记录
 type WxCallbackComponentRecord struct {
+	ID          int64     `gorm:"column:id;primaryKey" json:"id"`
 	ReceiveTime time.Time `gorm:"column:receivetime" json:"receiveTime"`
 	CreateTime  time.Time `gorm:"column:createtime" json:"createTime"`
 	InfoType    string    `gorm:"column:infotype" json:"infoType"`
 	PostBody    string    `gorm:"column:postbody" json:"postBody"`
+	DedupKey    string    `gorm:"column:dedupkey" json:"-"`
+	DupCount    int       `gorm:"column:dupcount" json:"dupCount"` // 被丢弃的重复推送次数
 }
 
 // WxCallbackBizRecord 小程序授权事件记录
 type WxCallbackBizRecord struct {
-	ReceiveTime time.Time `gorm:"column:receivetime" json:"receiveTime"`
-	CreateTime  time.Time `gorm:"column:createtime" json:"createTime"`
-	Appid       string    `gorm:"column:appid" json:"appid"`
-	ToUserName  string    `gorm:"column:tousername" json:"toUserName"`
-	MsgType     string    `gorm:"column:msgtype" json:"msgType"`
-	Event       string    `gorm:"column:event" json:"event"`
-	PostBody    string    `gorm:"column:postbody" json:"postBody"`
+	ID           int64     `gorm:"column:id;primaryKey" json:"id"`
+	ReceiveTime  time.Time `gorm:"column:receivetime" json:"receiveTime"`
+	CreateTime   time.Time `gorm:"column:createtime" json:"createTime"`
+	Appid        string    `gorm:"column:appid" json:"appid"`
+	ToUserName   string    `gorm:"column:tousername" json:"toUserName"`
+	FromUserName string    `gorm:"column:fromusername" json:"fromUserName"`
+	MsgType      string    `gorm:"column:msgtype" json:"msgType"`
+	Event        string    `gorm:"column:event" json:"event"`
+	PostBody     string    `gorm:"column:postbody" json:"postBody"`
+	DedupKey     string    `gorm:"column:dedupkey" json:"-"`
+	DupCount     int       `gorm:"column:dupcount" json:"dupCount"` // 被丢弃的重复推送次数
+}
+
+// WxCallbackDeliveryRecord 消息转发记录 每个转发目标一条
+type WxCallbackDeliveryRecord struct {
+	ID         int64     `gorm:"column:id;primaryKey" json:"id"`
+	RuleID     int32     `gorm:"column:ruleid" json:"ruleId"`
+	Appid      string    `gorm:"column:appid" json:"appid"`
+	InfoType   string    `gorm:"column:infotype" json:"infoType"`
+	MsgType    string    `gorm:"column:msgtype" json:"msgType"`
+	Event      string    `gorm:"column:event" json:"event"`
+	Target     string    `gorm:"column:target" json:"target"`
+	Primary    int       `gorm:"column:isprimary" json:"primary"`
+	StatusCode int       `gorm:"column:statuscode" json:"statusCode"`
+	Latency    int64     `gorm:"column:latency" json:"latency"`
+	Result     int       `gorm:"column:result" json:"result"`
+	ErrMsg     string    `gorm:"column:errmsg" json:"errMsg"`
+	ReplayID   int64     `gorm:"column:replayid" json:"replayId"` // 重放时为原消息记录的id
+	Late       int       `gorm:"column:late" json:"late"`         // 超过回复期限才收到回包 已先回复微信success
+	Response   string    `gorm:"column:response" json:"response"` // 迟到的回包内容
+	CreateTime time.Time `gorm:"column:createtime" json:"createTime"`
+}
+
+const DELIVERYRESULT_SUCC = 1
+const DELIVERYRESULT_FAIL = 2
+
+// WxCallbackDeliveryTask 异步转发任务 投递成功后删除 超过重试次数后转为死信
+type WxCallbackDeliveryTask struct {
+	ID            int64     `gorm:"column:id;primaryKey" json:"id"`
+	RuleID        int32     `gorm:"column:ruleid" json:"ruleId"`
+	TargetIndex   int       `gorm:"column:targetindex" json:"targetIndex"`
+	Appid         string    `gorm:"column:appid" json:"appid"`
+	InfoType      string    `gorm:"column:infotype" json:"infoType"`
+	MsgType       string    `gorm:"column:msgtype" json:"msgType"`
+	Event         string    `gorm:"column:event" json:"event"`
+	Header        string    `gorm:"column:header" json:"-"`
+	RawQuery      string    `gorm:"column:rawquery" json:"-"`
+	PostBody      string    `gorm:"column:postbody" json:"postBody"`
+	Status        int       `gorm:"column:status" json:"status"`
+	Attempts      int       `gorm:"column:attempts" json:"attempts"`
+	NextRetryTime time.Time `gorm:"column:nextretrytime" json:"nextRetryTime"`
+	LastError     string    `gorm:"column:lasterror" json:"lastError"`
+	ClaimToken    string    `gorm:"column:claimtoken" json:"-"` // 每次抢占后更新 用于判断任务是否已被其他实例抢占
+	CreateTime    time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime    time.Time `gorm:"column:updatetime;default:null" json:"updateTime"`
 }
 
+const DELIVERYTASK_PENDING = 1
+const DELIVERYTASK_DEAD = 2
+
 // MarshalJSON 重写struct转json方法
 func (r WxCallbackComponentRecord) MarshalJSON() ([]byte, error) {
 	type Alias WxCallbackComponentRecord
@@ -51,3 +105,31 @@ func (r WxCallbackBizRecord) MarshalJSON() ([]byte, error) {
 		CreateTime:  r.CreateTime.Unix(),
 	})
 }
+
+// MarshalJSON 重写struct转json方法
+func (r WxCallbackDeliveryRecord) MarshalJSON() ([]byte, error) {
+	type Alias WxCallbackDeliveryRecord
+	return json.Marshal(&struct {
+		Alias
+		CreateTime int64 `json:"createTime"`
+	}{
+		Alias:      (Alias)(r),
+		CreateTime: r.CreateTime.Unix(),
+	})
+}
+
+// MarshalJSON 重写struct转json方法
+func (r WxCallbackDeliveryTask) MarshalJSON() ([]byte, error) {
+	type Alias WxCallbackDeliveryTask
+	return json.Marshal(&struct {
+		Alias
+		NextRetryTime int64 `json:"nextRetryTime"`
+		CreateTime    int64 `json:"createTime"`
+		UpdateTime    int64 `json:"updateTime"`
+	}{
+		Alias:         (Alias)(r),
+		NextRetryTime: r.NextRetryTime.Unix(),
+		CreateTime:    r.CreateTime.Unix(),
+		UpdateTime:    r.UpdateTime.Unix(),
+	})
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WxCallbackRuleHistory 转发规则的变更记录 每次变更一条 version按规则递增
+type WxCallbackRuleHistory struct {
+	ID          int64     `gorm:"column:id;primaryKey" json:"id"`
+	RuleID      int32     `gorm:"column:ruleid" json:"ruleId"`
+	Version     int       `gorm:"column:version" json:"version"`
+	Action      string    `gorm:"column:action" json:"action"`
+	Operator    string    `gorm:"column:operator" json:"operator"`       // 操作的管理员用户名
+	FromVersion int       `gorm:"column:fromversion" json:"fromVersion"` // 恢复时为恢复到的版本
+	BeforeRule  string    `gorm:"column:beforerule" json:"before"`       // 变更前的规则 json 新增时为空
+	AfterRule   string    `gorm:"column:afterrule" json:"after"`         // 变更后的规则 json 删除时为空
+	CreateTime  time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+}
+
+// 规则变更的操作
+const (
+	RULEACTION_CREATE  = "create"
+	RULEACTION_UPDATE  = "update"
+	RULEACTION_DELETE  = "delete"
+	RULEACTION_RESTORE = "restore"
+)
+
+// MarshalJSON 重写struct转json方法 变更前后的规则输出为对象
+func (r WxCallbackRuleHistory) MarshalJSON() ([]byte, error) {
+	type Alias WxCallbackRuleHistory
+	return json.Marshal(&struct {
+		Alias
+		BeforeRule json.RawMessage `json:"before"`
+		AfterRule  json.RawMessage `json:"after"`
+		CreateTime int64           `json:"createTime"`
+	}{
+		Alias:      (Alias)(r),
+		BeforeRule: ruleSnapshotJson(maskRuleSnapshot(r.BeforeRule)),
+		AfterRule:  ruleSnapshotJson(maskRuleSnapshot(r.AfterRule)),
+		CreateTime: r.CreateTime.Unix(),
+	})
+}
+
+func ruleSnapshotJson(value string) json.RawMessage {
+	if value == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(value)
+}
+
+// maskRuleSnapshot 隐藏快照中的密钥 数据库中保留原文用于恢复
+func maskRuleSnapshot(value string) string {
+	if value == "" {
+		return value
+	}
+	rule, err := ParseRuleSnapshot(value)
+	if err != nil {
+		return value
+	}
+	rule.Info = MaskRuleInfo(rule.Type, rule.Info)
+	return GenRuleSnapshot(rule)
+}
+
+// GenRuleSnapshot 生成规则的快照 记录在变更历史中
+func GenRuleSnapshot(rule *WxCallbackRule) string {
+	if rule == nil {
+		return ""
+	}
+	value, _ := json.Marshal(rule)
+	return string(value)
+}
+
+// ParseRuleSnapshot 从快照还原规则
+func ParseRuleSnapshot(value string) (*WxCallbackRule, error) {
+	var rule WxCallbackRule
+	if err := json.Unmarshal([]byte(value), &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
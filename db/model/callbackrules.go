@@ -1,27 +1,489 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // WxCallbackRule 回调消息转发规则
 type WxCallbackRule struct {
-	ID         int32     `gorm:"column:id;primaryKey" json:"id"`
-	Name       string    `gorm:"column:name" json:"name"`
-	InfoType   string    `gorm:"column:infotype" json:"infoType"`
-	MsgType    string    `gorm:"column:msgtype" json:"msgType"`
-	Event      string    `gorm:"column:event" json:"event"`
-	Type       int       `gorm:"column:type" json:"type"`
-	Open       int       `gorm:"column:open" json:"open"`
-	Info       string    `gorm:"column:info" json:"info"`
-	CreateTime time.Time `gorm:"column:createtime;default:null" json:"createTime"`
-	UpdateTime time.Time `gorm:"column:updatetime;default:null" json:"updatetime"`
+	ID         int32  `gorm:"column:id;primaryKey" json:"id"`
+	Name       string `gorm:"column:name" json:"name"`
+	InfoType   string `gorm:"column:infotype" json:"infoType"`
+	MsgType    string `gorm:"column:msgtype" json:"msgType"`
+	Event      string `gorm:"column:event" json:"event"`
+	Appids     string `gorm:"column:appids" json:"appids"` // 逗号分隔 为空时对所有授权账号生效
+	Type       int    `gorm:"column:type" json:"type"`
+	Open       int    `gorm:"column:open" json:"open"`
+	Async      int    `gorm:"column:async" json:"async"`
+	Info       string `gorm:"column:info" json:"info"`
+	Conditions string `gorm:"column:conditions" json:"conditions"` // 消息内容匹配条件 json数组 为空时不检查
+	Transform  string `gorm:"column:transform" json:"transform"`   // 转发前改写消息内容 json 为空时转发原始消息
+	// 等待回包的期限 单位毫秒 为0时使用默认配置 为-1时一直等待 用于需要被动回复的规则
+	ReplyDeadline int       `gorm:"column:replydeadline" json:"replyDeadline"`
+	CreateTime    time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime    time.Time `gorm:"column:updatetime;default:null" json:"updatetime"`
+
+	conditions   []RuleCondition
+	parsed       bool
+	parseErr     error
+	transform    *RuleTransform
+	transformErr error
+}
+
+// CALLBACKRULE_WILDCARD 消息类型通配符
+const CALLBACKRULE_WILDCARD = "*"
+
+// Match 规则是否匹配该消息 *为通配符 appids为空时匹配所有授权账号
+func (r *WxCallbackRule) Match(appid string, infoType string, msgType string, event string) bool {
+	if r.Open == 0 {
+		return false
+	}
+	if r.Appids != "" && !r.HasAppid(appid) {
+		return false
+	}
+	if (infoType == "") != (r.InfoType == "") {
+		return false
+	}
+	if infoType != "" {
+		// 第三方授权事件只有infotype
+		return matchRuleField(r.InfoType, infoType)
+	}
+	return matchRuleField(r.MsgType, msgType) && matchRuleField(r.Event, event)
+}
+
+// Priority 规则的匹配优先级 多条规则同时匹配时取优先级最高的
+// 优先级从高到低：指定appid > 精确infotype/msgtype > 精确event > 有内容匹配条件
+func (r *WxCallbackRule) Priority() int {
+	priority := 0
+	if r.Appids != "" {
+		priority += 8
+	}
+	if r.InfoType != CALLBACKRULE_WILDCARD && r.MsgType != CALLBACKRULE_WILDCARD {
+		priority += 4
+	}
+	if r.InfoType != "" || r.Event != CALLBACKRULE_WILDCARD {
+		priority += 2
+	}
+	if r.Conditions != "" {
+		priority += 1
+	}
+	return priority
+}
+
+// ParseConditions 解析并缓存内容匹配条件 规则放入缓存前调用
+func (r *WxCallbackRule) ParseConditions() error {
+	r.conditions, r.parsed, r.parseErr = nil, true, nil
+	if r.Conditions == "" {
+		return nil
+	}
+	var conditions []RuleCondition
+	if err := json.Unmarshal([]byte(r.Conditions), &conditions); err != nil {
+		r.parseErr = err
+		return err
+	}
+	for i := range conditions {
+		if err := conditions[i].Compile(); err != nil {
+			r.parseErr = err
+			return err
+		}
+	}
+	r.conditions = conditions
+	return nil
+}
+
+// CheckConditions 检查消息内容是否满足全部条件 不满足时返回第一个不满足的条件
+func (r *WxCallbackRule) CheckConditions(msg map[string]interface{}) error {
+	if !r.parsed {
+		r.ParseConditions()
+	}
+	if r.parseErr != nil {
+		return r.parseErr
+	}
+	for i := range r.conditions {
+		if !r.conditions[i].Match(msg) {
+			return fmt.Errorf("条件不满足: %s %s %s", r.conditions[i].Field, r.conditions[i].Op,
+				r.conditions[i].Value)
+		}
+	}
+	return nil
+}
+
+// ParseTransform 解析并编译改写配置 规则放入缓存前调用
+func (r *WxCallbackRule) ParseTransform() error {
+	r.transform, r.transformErr = nil, nil
+	if r.Transform == "" {
+		return nil
+	}
+	var transform RuleTransform
+	if err := json.Unmarshal([]byte(r.Transform), &transform); err != nil {
+		r.transformErr = err
+		return err
+	}
+	if err := transform.Compile(); err != nil {
+		r.transformErr = err
+		return err
+	}
+	r.transform = &transform
+	return nil
+}
+
+// GetTransform 获取编译后的改写配置 未配置时返回nil
+func (r *WxCallbackRule) GetTransform() (*RuleTransform, error) {
+	if r.transform == nil && r.transformErr == nil && r.Transform != "" {
+		r.ParseTransform()
+	}
+	return r.transform, r.transformErr
+}
+
+func matchRuleField(ruleValue string, value string) bool {
+	return ruleValue == CALLBACKRULE_WILDCARD || ruleValue == value
+}
+
+// GetAppids 获取规则限定的appid列表
+func (r *WxCallbackRule) GetAppids() []string {
+	if r.Appids == "" {
+		return []string{}
+	}
+	return strings.Split(r.Appids, ",")
+}
+
+// HasAppid 规则是否限定了该appid
+func (r *WxCallbackRule) HasAppid(appid string) bool {
+	for _, v := range r.GetAppids() {
+		if v == appid {
+			return true
+		}
+	}
+	return false
+}
+
+// 内容匹配条件的比较方式
+const (
+	CONDITIONOP_EQ       = "eq"
+	CONDITIONOP_NE       = "ne"
+	CONDITIONOP_CONTAINS = "contains"
+	CONDITIONOP_PREFIX   = "prefix"
+	CONDITIONOP_SUFFIX   = "suffix"
+	CONDITIONOP_REGEX    = "regex"
+	CONDITIONOP_GT       = "gt"
+	CONDITIONOP_GTE      = "gte"
+	CONDITIONOP_LT       = "lt"
+	CONDITIONOP_LTE      = "lte"
+	CONDITIONOP_EXISTS   = "exists"
+)
+
+// RuleCondition 消息内容匹配条件 field为消息中的字段 嵌套字段用.分隔
+type RuleCondition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+
+	regex  *regexp.Regexp
+	number float64
+}
+
+// Compile 检查条件格式 预编译正则和数值
+func (c *RuleCondition) Compile() error {
+	if c.Field == "" {
+		return errors.New("条件字段为空")
+	}
+	switch c.Op {
+	case CONDITIONOP_EQ, CONDITIONOP_NE, CONDITIONOP_CONTAINS, CONDITIONOP_PREFIX,
+		CONDITIONOP_SUFFIX, CONDITIONOP_EXISTS:
+	case CONDITIONOP_REGEX:
+		regex, err := regexp.Compile(c.Value)
+		if err != nil {
+			return fmt.Errorf("正则格式有误: %v", err)
+		}
+		c.regex = regex
+	case CONDITIONOP_GT, CONDITIONOP_GTE, CONDITIONOP_LT, CONDITIONOP_LTE:
+		number, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return fmt.Errorf("条件%s的值需为数字", c.Field)
+		}
+		c.number = number
+	default:
+		return fmt.Errorf("不支持的比较方式: %s", c.Op)
+	}
+	return nil
+}
+
+// Match 消息是否满足条件 需先调用Compile
+func (c *RuleCondition) Match(msg map[string]interface{}) bool {
+	value, ok := getMsgField(msg, c.Field)
+	if c.Op == CONDITIONOP_EXISTS {
+		return ok
+	}
+	if !ok {
+		return c.Op == CONDITIONOP_NE
+	}
+	str := msgFieldString(value)
+	switch c.Op {
+	case CONDITIONOP_EQ:
+		return str == c.Value
+	case CONDITIONOP_NE:
+		return str != c.Value
+	case CONDITIONOP_CONTAINS:
+		return strings.Contains(str, c.Value)
+	case CONDITIONOP_PREFIX:
+		return strings.HasPrefix(str, c.Value)
+	case CONDITIONOP_SUFFIX:
+		return strings.HasSuffix(str, c.Value)
+	case CONDITIONOP_REGEX:
+		return c.regex != nil && c.regex.MatchString(str)
+	}
+	number, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return false
+	}
+	switch c.Op {
+	case CONDITIONOP_GT:
+		return number > c.number
+	case CONDITIONOP_GTE:
+		return number >= c.number
+	case CONDITIONOP_LT:
+		return number < c.number
+	case CONDITIONOP_LTE:
+		return number <= c.number
+	}
+	return false
+}
+
+func getMsgField(msg map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = msg
+	for _, key := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func msgFieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return fmt.Sprint(v)
+	}
 }
 
 // HttpProxyConfig http转发配置
 type HttpProxyConfig struct {
-	Port int    `json:"port"`
-	Path string `json:"path"`
+	Port    int               `json:"port"`
+	Path    string            `json:"path"`
+	Targets []HttpProxyTarget `json:"targets,omitempty"`
+}
+
+// HttpProxyTarget 转发目标 主目标的回包返回给微信 其余目标异步投递副本
+type HttpProxyTarget struct {
+	Port    int    `json:"port"`
+	Path    string `json:"path"`
+	Primary bool   `json:"primary"`
+}
+
+// GetTargets 获取全部转发目标 主目标排在第一位 未配置targets时使用port和path
+func (c *HttpProxyConfig) GetTargets() []HttpProxyTarget {
+	if len(c.Targets) == 0 {
+		return []HttpProxyTarget{{Port: c.Port, Path: c.Path, Primary: true}}
+	}
+	primary := 0
+	for i, v := range c.Targets {
+		if v.Primary {
+			primary = i
+			break
+		}
+	}
+	targets := make([]HttpProxyTarget, 0, len(c.Targets))
+	targets = append(targets, c.Targets[primary])
+	targets[0].Primary = true
+	for i, v := range c.Targets {
+		if i != primary {
+			v.Primary = false
+			targets = append(targets, v)
+		}
+	}
+	return targets
+}
+
+// WebhookConfig 外部webhook转发配置
+type WebhookConfig struct {
+	Url     string            `json:"url"`
+	Secret  string            `json:"secret"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout int               `json:"timeout"` // 超时时间 单位毫秒
+}
+
+// SECRET_MASK 返回给管理端时代替密钥的掩码
+const SECRET_MASK = "******"
+
+// MaskRuleInfo 隐藏转发配置中的密钥 用于返回给管理端
+// webhook的secret和headers的值、消息队列的options的值都可能是凭证
+func MaskRuleInfo(ruleType int, info string) string {
+	switch ruleType {
+	case PROXYTYPE_WEBHOOK:
+		var webhook WebhookConfig
+		if err := json.Unmarshal([]byte(info), &webhook); err != nil {
+			return info
+		}
+		if webhook.Secret != "" {
+			webhook.Secret = SECRET_MASK
+		}
+		webhook.Headers = maskValues(webhook.Headers)
+		value, _ := json.Marshal(webhook)
+		return string(value)
+	case PROXYTYPE_MQ:
+		var config MQSinkConfig
+		if err := json.Unmarshal([]byte(info), &config); err != nil {
+			return info
+		}
+		config.Options = maskValues(config.Options)
+		value, _ := json.Marshal(config)
+		return string(value)
+	}
+	return info
+}
+
+func maskValues(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		if v != "" {
+			v = SECRET_MASK
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// RestoreMaskedRuleInfo 管理端提交的配置中仍为掩码的密钥未修改 沿用原规则的值
+// 原规则为空、类型不同或没有对应的值时无法恢复 返回错误
+func RestoreMaskedRuleInfo(ruleType int, info string, old *WxCallbackRule) (string, error) {
+	switch ruleType {
+	case PROXYTYPE_WEBHOOK:
+		var webhook, oldWebhook WebhookConfig
+		if err := json.Unmarshal([]byte(info), &webhook); err != nil {
+			return "", err
+		}
+		if old != nil && old.Type == PROXYTYPE_WEBHOOK {
+			json.Unmarshal([]byte(old.Info), &oldWebhook)
+		}
+		if webhook.Secret == SECRET_MASK {
+			if oldWebhook.Secret == "" {
+				return "", errors.New("webhook密钥为掩码 请重新填写")
+			}
+			webhook.Secret = oldWebhook.Secret
+		}
+		if err := restoreMaskedValues(webhook.Headers, oldWebhook.Headers, "header"); err != nil {
+			return "", err
+		}
+		value, _ := json.Marshal(webhook)
+		return string(value), nil
+	case PROXYTYPE_MQ:
+		var config, oldConfig MQSinkConfig
+		if err := json.Unmarshal([]byte(info), &config); err != nil {
+			return "", err
+		}
+		if old != nil && old.Type == PROXYTYPE_MQ {
+			json.Unmarshal([]byte(old.Info), &oldConfig)
+		}
+		if err := restoreMaskedValues(config.Options, oldConfig.Options, "option"); err != nil {
+			return "", err
+		}
+		value, _ := json.Marshal(config)
+		return string(value), nil
+	}
+	return info, nil
+}
+
+func restoreMaskedValues(values map[string]string, oldValues map[string]string, name string) error {
+	for k, v := range values {
+		if v != SECRET_MASK {
+			continue
+		}
+		if oldValues[k] == "" {
+			return fmt.Errorf("%s %s为掩码 请重新填写", name, k)
+		}
+		values[k] = oldValues[k]
+	}
+	return nil
+}
+
+// FileSinkConfig 文件转发配置 每条消息追加一行json 超过大小后轮转
+type FileSinkConfig struct {
+	Path       string `json:"path"`       // 文件路径 支持$APPID$
+	MaxSize    int    `json:"maxSize"`    // 单个文件的最大大小 单位MB 为0时不轮转
+	MaxBackups int    `json:"maxBackups"` // 保留的轮转文件数 为0时不删除
+}
+
+// Check 检查文件转发配置
+func (c *FileSinkConfig) Check() error {
+	if c.Path == "" {
+		return errors.New("文件路径为空")
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		return errors.New("文件大小和保留数不能为负数")
+	}
+	return nil
+}
+
+// CommandSinkConfig 本地命令转发配置 消息内容写入命令的标准输入
+type CommandSinkConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Timeout int      `json:"timeout"` // 超时时间 单位毫秒
+}
+
+// Check 检查命令转发配置
+func (c *CommandSinkConfig) Check() error {
+	if c.Command == "" {
+		return errors.New("命令为空")
+	}
+	if c.Timeout < 0 || c.Timeout > 30000 {
+		return errors.New("超时时间需在30000毫秒以内")
+	}
+	return nil
+}
+
+// MQSinkConfig 消息队列转发配置 driver为注册的消息队列实现 消息的key为appid
+type MQSinkConfig struct {
+	Driver  string            `json:"driver"`
+	Addr    string            `json:"addr"`
+	Topic   string            `json:"topic"` // 支持$APPID$
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Check 检查消息队列转发配置
+func (c *MQSinkConfig) Check() error {
+	if c.Driver == "" || c.Topic == "" {
+		return errors.New("driver和topic不能为空")
+	}
+	return nil
 }
 
 const PROXYTYPE_HTTP = 1
+const PROXYTYPE_WEBHOOK = 2
+const PROXYTYPE_FILE = 3
+const PROXYTYPE_COMMAND = 4
+const PROXYTYPE_MQ = 5
 const CALLBACKTYPE_COM = 1
 const CALLBACKTYPE_BIZ = 2
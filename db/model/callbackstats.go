@@ -0,0 +1,28 @@
+package model
+
+// CallbackStatPoint 消息统计时间序列中的一个点 时间为unix时间戳 未参与分组的字段为空
+// 统计表中消息数的ruleid为0 转发数按规则统计
+type CallbackStatPoint struct {
+	Time         int64  `gorm:"column:time" json:"time"`
+	Appid        string `gorm:"column:appid" json:"appid,omitempty"`
+	InfoType     string `gorm:"column:infotype" json:"infoType,omitempty"`
+	MsgType      string `gorm:"column:msgtype" json:"msgType,omitempty"`
+	Event        string `gorm:"column:event" json:"event,omitempty"`
+	RuleID       int32  `gorm:"column:ruleid" json:"ruleId,omitempty"`
+	MsgCount     int64  `gorm:"column:msgcount" json:"msgCount"`
+	DupCount     int64  `gorm:"column:dupcount" json:"dupCount"`
+	DeliverCount int64  `gorm:"column:delivercount" json:"deliverCount"`
+	DeliverSucc  int64  `gorm:"column:deliversucc" json:"deliverSucc"`
+	DeliverFail  int64  `gorm:"column:deliverfail" json:"deliverFail"`
+	LatencyAvg   int64  `gorm:"column:latencyavg" json:"latencyAvg"` // 平均转发耗时 单位毫秒
+	LatencyMax   int64  `gorm:"column:latencymax" json:"latencyMax"`
+}
+
+// 统计的时间粒度
+const STATGRANULARITY_HOUR = "hour"
+const STATGRANULARITY_DAY = "day"
+
+// 统计的分组维度
+const STATGROUP_APPID = "appid"
+const STATGROUP_EVENT = "event"
+const STATGROUP_RULE = "rule"
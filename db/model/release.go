@@ -0,0 +1,108 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WxReleaseState 代开发小程序的发布状态 每个授权账号一条
+type WxReleaseState struct {
+	ID          int32     `gorm:"column:id;primaryKey" json:"-"`
+	Appid       string    `gorm:"column:appid" json:"appid"`
+	State       int       `gorm:"column:state" json:"state"`
+	UserVersion string    `gorm:"column:userversion" json:"userVersion"`
+	AuditId     int64     `gorm:"column:auditid" json:"auditId"`
+	AutoRelease int       `gorm:"column:autorelease" json:"autoRelease"` // 审核通过后自动发布
+	Reason      string    `gorm:"column:reason" json:"reason"`
+	CreateTime  time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime  time.Time `gorm:"column:updatetime;default:null" json:"updateTime"`
+}
+
+// WxReleaseHistory 发布状态的变更记录
+type WxReleaseHistory struct {
+	ID          int64     `gorm:"column:id;primaryKey" json:"id"`
+	Appid       string    `gorm:"column:appid" json:"appid"`
+	FromState   int       `gorm:"column:fromstate" json:"fromState"`
+	ToState     int       `gorm:"column:tostate" json:"toState"`
+	UserVersion string    `gorm:"column:userversion" json:"userVersion"`
+	AuditId     int64     `gorm:"column:auditid" json:"auditId"`
+	Source      string    `gorm:"column:source" json:"source"`
+	Reason      string    `gorm:"column:reason" json:"reason"`
+	CreateTime  time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+}
+
+// 发布状态 提交代码 -> 审核中 -> 审核通过/不通过 -> 已发布
+const (
+	RELEASESTATE_NONE      = 0
+	RELEASESTATE_COMMITTED = 1
+	RELEASESTATE_AUDITING  = 2
+	RELEASESTATE_AUDITPASS = 3
+	RELEASESTATE_AUDITFAIL = 4
+	RELEASESTATE_RELEASED  = 5
+)
+
+// releaseTransitions 允许的状态变更 from -> to
+// 开始跟踪前的状态未知 可变更为任意状态 提交代码和提交审核不受之前状态限制
+var releaseTransitions = map[int][]int{
+	RELEASESTATE_NONE: {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING, RELEASESTATE_AUDITPASS,
+		RELEASESTATE_AUDITFAIL, RELEASESTATE_RELEASED},
+	RELEASESTATE_COMMITTED: {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING},
+	RELEASESTATE_AUDITING: {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING, RELEASESTATE_AUDITPASS,
+		RELEASESTATE_AUDITFAIL, RELEASESTATE_RELEASED},
+	RELEASESTATE_AUDITPASS: {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING, RELEASESTATE_AUDITPASS,
+		RELEASESTATE_RELEASED},
+	RELEASESTATE_AUDITFAIL: {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING},
+	RELEASESTATE_RELEASED:  {RELEASESTATE_COMMITTED, RELEASESTATE_AUDITING},
+}
+
+// CanTransitRelease 是否允许从from变更为to
+// 审核事件只对审核中的版本生效 重复或迟到的推送不会改变已审核或已发布的状态
+func CanTransitRelease(from int, to int, source string) bool {
+	if source == RELEASESOURCE_EVENT && from != RELEASESTATE_NONE && from != RELEASESTATE_AUDITING {
+		return false
+	}
+	for _, v := range releaseTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 状态变更的来源
+const (
+	RELEASESOURCE_MANUAL = "manual" // 管理端操作
+	RELEASESOURCE_EVENT  = "event"  // 微信推送的审核事件
+	RELEASESOURCE_AUTO   = "auto"   // 审核通过后自动发布
+)
+
+// 代码审核结果推送的事件
+const WEAPPAUDIT_SUCCESS = "weapp_audit_success"
+const WEAPPAUDIT_FAIL = "weapp_audit_fail"
+const WEAPPAUDIT_DELAY = "weapp_audit_delay"
+
+// MarshalJSON 重写struct转json方法
+func (r WxReleaseState) MarshalJSON() ([]byte, error) {
+	type Alias WxReleaseState
+	return json.Marshal(&struct {
+		Alias
+		CreateTime int64 `json:"createTime"`
+		UpdateTime int64 `json:"updateTime"`
+	}{
+		Alias:      (Alias)(r),
+		CreateTime: r.CreateTime.Unix(),
+		UpdateTime: r.UpdateTime.Unix(),
+	})
+}
+
+// MarshalJSON 重写struct转json方法
+func (r WxReleaseHistory) MarshalJSON() ([]byte, error) {
+	type Alias WxReleaseHistory
+	return json.Marshal(&struct {
+		Alias
+		CreateTime int64 `json:"createTime"`
+	}{
+		Alias:      (Alias)(r),
+		CreateTime: r.CreateTime.Unix(),
+	})
+}
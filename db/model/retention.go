@@ -0,0 +1,24 @@
+package model
+
+// RetentionPolicy 消息记录表的保留策略 天数和行数为0时不限制
+type RetentionPolicy struct {
+	Table      string `json:"table"`
+	TimeColumn string `json:"-"`
+	Condition  string `json:"-"` // 只清理满足条件的行 为空时不限制
+	MaxDays    int    `json:"maxDays"`
+	MaxRows    int64  `json:"maxRows"`
+}
+
+// RetentionPurgeResult 一次清理的结果 时间为unix时间戳
+type RetentionPurgeResult struct {
+	StartTime int64                  `json:"startTime"`
+	EndTime   int64                  `json:"endTime"`
+	Tables    []RetentionTableResult `json:"tables"`
+}
+
+// RetentionTableResult 单个表的清理结果
+type RetentionTableResult struct {
+	Table   string `json:"table"`
+	Deleted int64  `json:"deleted"`
+	ErrMsg  string `json:"errMsg,omitempty"`
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// ServiceClient 内部服务的客户端 按appid和token类型限制可获取的凭证
+type ServiceClient struct {
+	ID         int32      `gorm:"column:id;primaryKey" json:"id"`
+	Name       string     `gorm:"column:name" json:"name"`
+	Secret     string     `gorm:"column:secret" json:"-"`              // 读取后为明文 数据库中加密存储
+	Appids     string     `gorm:"column:appids" json:"appids"`         // 逗号分隔 为*时对所有授权账号生效
+	TokenTypes string     `gorm:"column:tokentypes" json:"tokenTypes"` // 逗号分隔
+	Open       int        `gorm:"column:open" json:"open"`
+	ExpireTime *time.Time `gorm:"column:expiretime" json:"expireTime"` // 为空时不过期
+	CreateTime time.Time  `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime time.Time  `gorm:"column:updatetime;default:null" json:"updateTime"`
+}
+
+// 客户端可获取的凭证类型
+const (
+	SERVICETOKEN_COMPONENT  = "component"  // 第三方平台token
+	SERVICETOKEN_AUTHORIZER = "authorizer" // 授权账号token
+	SERVICETOKEN_TICKET     = "ticket"     // component_verify_ticket
+)
+
+// SERVICECLIENT_ALLAPPID 对所有授权账号生效
+const SERVICECLIENT_ALLAPPID = "*"
+
+// Expired 是否已过期
+func (c *ServiceClient) Expired() bool {
+	return c.ExpireTime != nil && c.ExpireTime.Before(time.Now())
+}
+
+// AllowToken 是否可获取该类凭证 授权账号token还需检查appid
+func (c *ServiceClient) AllowToken(tokenType string, appid string) bool {
+	if !containsItem(c.TokenTypes, tokenType) {
+		return false
+	}
+	if tokenType != SERVICETOKEN_AUTHORIZER {
+		return true
+	}
+	return appid != "" && (containsItem(c.Appids, SERVICECLIENT_ALLAPPID) || containsItem(c.Appids, appid))
+}
+
+func containsItem(list string, item string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
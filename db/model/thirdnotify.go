@@ -0,0 +1,48 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WxThirdNotify 第三方平台通知的处理结果 同一对象的多次通知更新同一条记录
+type WxThirdNotify struct {
+	ID         int64     `gorm:"column:id;primaryKey" json:"id"`
+	InfoType   string    `gorm:"column:infotype" json:"infoType"`
+	Appid      string    `gorm:"column:appid" json:"appid"`
+	UniqKey    string    `gorm:"column:uniqkey" json:"uniqKey"`
+	Status     int       `gorm:"column:status" json:"status"`
+	Msg        string    `gorm:"column:msg" json:"msg"`
+	Info       string    `gorm:"column:info" json:"info"`
+	CreateTime time.Time `gorm:"column:createtime;default:null" json:"createTime"`
+	UpdateTime time.Time `gorm:"column:updatetime;default:null" json:"updateTime"`
+}
+
+// 通知类型 名称审核结果推送到消息与事件URL 以Event区分
+const THIRDNOTIFY_FASTREGISTER = "notify_third_fasteregister"
+const THIRDNOTIFY_FASTVERIFYBETAAPP = "notify_third_fastverifybetaapp"
+const THIRDNOTIFY_NICKNAMEAUDIT = "wxa_nickname_audit"
+
+// 名称审核结果 ret字段
+const NICKNAMEAUDIT_REJECT = 2
+const NICKNAMEAUDIT_PASS = 3
+
+// MarshalJSON 重写struct转json方法
+func (r WxThirdNotify) MarshalJSON() ([]byte, error) {
+	type Alias WxThirdNotify
+	info := json.RawMessage("{}")
+	if json.Valid([]byte(r.Info)) {
+		info = json.RawMessage(r.Info)
+	}
+	return json.Marshal(&struct {
+		Alias
+		Info       json.RawMessage `json:"info"`
+		CreateTime int64           `json:"createTime"`
+		UpdateTime int64           `json:"updateTime"`
+	}{
+		Alias:      (Alias)(r),
+		Info:       info,
+		CreateTime: r.CreateTime.Unix(),
+		UpdateTime: r.UpdateTime.Unix(),
+	})
+}
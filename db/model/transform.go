@@ -0,0 +1,140 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// RuleTransform 转发前改写消息内容 可引用的数据见TransformData
+// template为text/template模板 渲染结果作为转发内容
+// mapping为json 其中以$开头的字符串替换为引用的数据 如"$msg.FromUserName"、"$authorizer.nickName" 以$$开头时表示字符串$
+type RuleTransform struct {
+	Type     string          `json:"type"`
+	Template string          `json:"template,omitempty"`
+	Mapping  json.RawMessage `json:"mapping,omitempty"`
+
+	tmpl    *template.Template
+	mapping interface{}
+}
+
+// 改写方式
+const TRANSFORMTYPE_TEMPLATE = "template"
+const TRANSFORMTYPE_MAPPING = "mapping"
+
+var transformFuncs = template.FuncMap{
+	// json 输出值的json 用于在json模板中嵌入字符串或对象
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Compile 检查格式 预编译模板和映射
+func (t *RuleTransform) Compile() error {
+	switch t.Type {
+	case TRANSFORMTYPE_TEMPLATE:
+		tmpl, err := template.New("transform").Funcs(transformFuncs).Parse(t.Template)
+		if err != nil {
+			return fmt.Errorf("模板格式有误: %v", err)
+		}
+		t.tmpl = tmpl
+	case TRANSFORMTYPE_MAPPING:
+		d := json.NewDecoder(bytes.NewReader(t.Mapping))
+		d.UseNumber()
+		if err := d.Decode(&t.mapping); err != nil {
+			return fmt.Errorf("映射格式有误: %v", err)
+		}
+	default:
+		return fmt.Errorf("不支持的改写方式: %s", t.Type)
+	}
+	return nil
+}
+
+// TransformData 改写时可引用的数据 模板中为.appid、.msg.FromUserName等 映射中为$appid、$msg.FromUserName等
+type TransformData struct {
+	Appid      string
+	RuleID     int32
+	Now        int64
+	Raw        string                 // 原始消息内容
+	Msg        map[string]interface{} // 解析后的消息
+	Authorizer map[string]interface{} // 授权账号信息 见GenTransformAuthorizer
+}
+
+func (d *TransformData) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"appid":      d.Appid,
+		"ruleId":     d.RuleID,
+		"now":        d.Now,
+		"raw":        d.Raw,
+		"msg":        d.Msg,
+		"authorizer": d.Authorizer,
+	}
+}
+
+// GenTransformAuthorizer 可在改写中引用的授权账号信息 不包含refresh_token等敏感字段
+func GenTransformAuthorizer(record *Authorizer) map[string]interface{} {
+	if record == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"appid":         record.Appid,
+		"appType":       record.AppType,
+		"serviceType":   record.ServiceType,
+		"nickName":      record.NickName,
+		"userName":      record.UserName,
+		"headImg":       record.HeadImg,
+		"principalName": record.PrincipalName,
+		"verifyInfo":    record.VerifyInfo,
+	}
+}
+
+// Render 改写消息内容 需先调用Compile
+func (t *RuleTransform) Render(data *TransformData) (string, error) {
+	root := data.toMap()
+	switch t.Type {
+	case TRANSFORMTYPE_TEMPLATE:
+		if t.tmpl == nil {
+			return "", errors.New("模板未编译")
+		}
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, root); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case TRANSFORMTYPE_MAPPING:
+		b, err := json.Marshal(resolveMapping(t.mapping, root))
+		return string(b), err
+	}
+	return "", fmt.Errorf("不支持的改写方式: %s", t.Type)
+}
+
+func resolveMapping(value interface{}, root map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = resolveMapping(item, root)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = resolveMapping(item, root)
+		}
+		return result
+	case string:
+		if strings.HasPrefix(v, "$$") {
+			return v[1:]
+		}
+		if strings.HasPrefix(v, "$") {
+			// 引用的数据不存在时为null
+			field, _ := getMsgField(root, v[1:])
+			return field
+		}
+	}
+	return value
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// memoryStore 存储在进程内存中 重启后丢失 只适用于单实例部署和测试
+type memoryStore struct {
+	mutex  sync.Mutex
+	tokens map[memoryTokenKey]model.WxToken
+	ticket string
+	locks  map[string]memoryLock
+	// 随机串的过期时间
+	nonces         map[string]time.Time
+	lastNonceClean time.Time
+}
+
+type memoryTokenKey struct {
+	appid     string
+	tokenType int
+}
+
+type memoryLock struct {
+	value      string
+	createTime time.Time
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() Store {
+	return &memoryStore{
+		tokens: make(map[memoryTokenKey]model.WxToken),
+		locks:  make(map[string]memoryLock),
+		nonces: make(map[string]time.Time),
+	}
+}
+
+func (s *memoryStore) GetToken(appid string, tokenType int) (*model.WxToken, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record, ok := s.tokens[memoryTokenKey{appid, tokenType}]
+	if !ok {
+		return nil, false, nil
+	}
+	return &record, true, nil
+}
+
+func (s *memoryStore) SetToken(record *model.WxToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := memoryTokenKey{record.Appid, record.Type}
+	value := *record
+	value.UpdateTime = time.Now()
+	if old, ok := s.tokens[key]; ok {
+		value.CreateTime = old.CreateTime
+	} else {
+		value.CreateTime = value.UpdateTime
+	}
+	s.tokens[key] = value
+	return nil
+}
+
+func (s *memoryStore) DelToken(appid string, tokenType int, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := memoryTokenKey{appid, tokenType}
+	if record, ok := s.tokens[key]; ok && record.Token == token {
+		delete(s.tokens, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) ListTokens() ([]*model.WxToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records := make([]*model.WxToken, 0, len(s.tokens))
+	for _, v := range s.tokens {
+		records = append(records, &model.WxToken{Type: v.Type, Appid: v.Appid, Expiretime: v.Expiretime,
+			UpdateTime: v.UpdateTime})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return records[i].Type > records[j].Type
+		}
+		return records[i].Appid < records[j].Appid
+	})
+	return records, nil
+}
+
+func (s *memoryStore) GetTicket() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ticket, nil
+}
+
+func (s *memoryStore) SetTicket(ticket string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ticket = ticket
+	return nil
+}
+
+func (s *memoryStore) Lock(key string, value string, expire time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lock, ok := s.locks[key]; ok && time.Since(lock.createTime) < expire {
+		return errors.New("lock is held by " + lock.value)
+	}
+	s.locks[key] = memoryLock{value: value, createTime: time.Now()}
+	return nil
+}
+
+func (s *memoryStore) UnLock(key string, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lock, ok := s.locks[key]; ok && lock.value == value {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) AddNonce(key string, expire time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastNonceClean) >= nonceCleanInterval {
+		for k, v := range s.nonces {
+			if now.After(v) {
+				delete(s.nonces, k)
+			}
+		}
+		s.lastNonceClean = now
+	}
+	if expireTime, ok := s.nonces[key]; ok && now.Before(expireTime) {
+		return false, nil
+	}
+	s.nonces[key] = now.Add(expire)
+	return true, nil
+}
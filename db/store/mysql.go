@@ -0,0 +1,84 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// ticket在comm表中的key
+const ticketKey = "ticket"
+
+// comm表中随机串的key前缀
+const nonceKeyPrefix = "nonce_"
+
+// nonceCleanInterval 清理过期随机串的间隔
+const nonceCleanInterval = time.Minute
+
+// mysqlStore token存储在wxtoken表 ticket、锁和随机串存储在comm表
+type mysqlStore struct {
+	mutex          sync.Mutex
+	lastNonceClean time.Time
+}
+
+// NewMysqlStore 创建mysql存储
+func NewMysqlStore() Store {
+	return &mysqlStore{}
+}
+
+func (s *mysqlStore) GetToken(appid string, tokenType int) (*model.WxToken, bool, error) {
+	return dao.GetAccessToken(appid, tokenType)
+}
+
+func (s *mysqlStore) SetToken(record *model.WxToken) error {
+	return dao.SetAccessToken(record)
+}
+
+func (s *mysqlStore) DelToken(appid string, tokenType int, token string) error {
+	return dao.DelAccessToken(appid, tokenType, token)
+}
+
+func (s *mysqlStore) ListTokens() ([]*model.WxToken, error) {
+	return dao.GetAccessTokenList()
+}
+
+func (s *mysqlStore) GetTicket() (string, error) {
+	return dao.GetCommKvWithCache(ticketKey, "", 15*time.Minute), nil
+}
+
+func (s *mysqlStore) SetTicket(ticket string) error {
+	return dao.SetCommKvWithCache(ticketKey, ticket, 15*time.Minute)
+}
+
+func (s *mysqlStore) Lock(key string, value string, expire time.Duration) error {
+	return dao.Lock(key, value, expire)
+}
+
+func (s *mysqlStore) UnLock(key string, value string) error {
+	return dao.UnLockWithValue(key, value)
+}
+
+// AddNonce 随机串作为comm表的主键写入 过期的记录定期清理
+func (s *mysqlStore) AddNonce(key string, expire time.Duration) (bool, error) {
+	s.cleanNonces(expire)
+	if err := dao.Lock(nonceKeyPrefix+key, "1", expire); err != nil {
+		if dao.GetCommKv(nonceKeyPrefix+key, "") != "" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *mysqlStore) cleanNonces(expire time.Duration) {
+	s.mutex.Lock()
+	if time.Since(s.lastNonceClean) < nonceCleanInterval {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastNonceClean = time.Now()
+	s.mutex.Unlock()
+	dao.DelExpiredCommKvWithPrefix(nonceKeyPrefix, expire)
+}
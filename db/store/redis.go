@@ -0,0 +1,204 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// redisTokenRetention token过期后在redis中保留的时间 便于查看刷新状态
+const redisTokenRetention = time.Hour
+
+// redisStore token、ticket、锁和随机串存储在redis中 key带配置的前缀 锁和随机串使用SET NX PX
+type redisStore struct {
+	client *redisClient
+	prefix string
+}
+
+// NewRedisStore 创建redis存储 创建时检查连接
+func NewRedisStore(conf *config.Store) (Store, error) {
+	s := &redisStore{
+		client: newRedisClient(conf.RedisAddr, conf.RedisPassword, conf.RedisDB,
+			time.Duration(conf.RedisTimeout)*time.Millisecond, conf.RedisMaxIdle),
+		prefix: conf.RedisPrefix,
+	}
+	if _, err := s.client.do("PING"); err != nil {
+		log.Errorf("redis ping err %v", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *redisStore) tokenKey(appid string, tokenType int) string {
+	return fmt.Sprintf("%stoken:%d:%s", s.prefix, tokenType, appid)
+}
+
+func (s *redisStore) GetToken(appid string, tokenType int) (*model.WxToken, bool, error) {
+	reply, err := s.client.do("GET", s.tokenKey(appid, tokenType))
+	if err != nil {
+		log.Error(err)
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	var record model.WxToken
+	if err = json.Unmarshal([]byte(reply.(string)), &record); err != nil {
+		log.Error(err)
+		return nil, false, err
+	}
+	return &record, true, nil
+}
+
+func (s *redisStore) SetToken(record *model.WxToken) error {
+	value := *record
+	value.UpdateTime = time.Now()
+	if value.CreateTime.IsZero() {
+		value.CreateTime = value.UpdateTime
+	}
+	data, _ := json.Marshal(&value)
+	ttl := time.Until(value.Expiretime)
+	if ttl < 0 {
+		ttl = 0
+	}
+	ttl += redisTokenRetention
+	if _, err := s.client.do("SET", s.tokenKey(record.Appid, record.Type), string(data),
+		"PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// DelToken 先比较再删除 调用方持有该token的锁 其他写入方同样需要持有锁 因此无需原子操作
+func (s *redisStore) DelToken(appid string, tokenType int, token string) error {
+	record, found, err := s.GetToken(appid, tokenType)
+	if err != nil || !found || record.Token != token {
+		return err
+	}
+	if _, err = s.client.do("DEL", s.tokenKey(appid, tokenType)); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (s *redisStore) ListTokens() ([]*model.WxToken, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.client.do("SCAN", cursor, "MATCH", s.prefix+"token:*", "COUNT", "100")
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return nil, errors.New("redis: invalid scan reply")
+		}
+		cursor, _ = values[0].(string)
+		batch, _ := values[1].([]interface{})
+		for _, v := range batch {
+			if key, ok := v.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	records := make([]*model.WxToken, 0, len(keys))
+	for begin := 0; begin < len(keys); begin += 100 {
+		end := begin + 100
+		if end > len(keys) {
+			end = len(keys)
+		}
+		reply, err := s.client.do(append([]string{"MGET"}, keys[begin:end]...)...)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		values, _ := reply.([]interface{})
+		for _, v := range values {
+			value, ok := v.(string)
+			if !ok {
+				// 扫描后已过期
+				continue
+			}
+			var record model.WxToken
+			if err = json.Unmarshal([]byte(value), &record); err != nil {
+				log.Error(err)
+				continue
+			}
+			record.Token = ""
+			records = append(records, &record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return records[i].Type > records[j].Type
+		}
+		return records[i].Appid < records[j].Appid
+	})
+	return records, nil
+}
+
+func (s *redisStore) GetTicket() (string, error) {
+	reply, err := s.client.do("GET", s.prefix+ticketKey)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	ticket, _ := reply.(string)
+	return ticket, nil
+}
+
+func (s *redisStore) SetTicket(ticket string) error {
+	if _, err := s.client.do("SET", s.prefix+ticketKey, ticket); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (s *redisStore) Lock(key string, value string, expire time.Duration) error {
+	reply, err := s.client.do("SET", s.prefix+"lock:"+key, value, "NX", "PX",
+		strconv.FormatInt(expire.Milliseconds(), 10))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if reply == nil {
+		return errors.New("lock is held")
+	}
+	return nil
+}
+
+// redisUnLockScript 比较锁的值后删除 GET和DEL在redis中原子执行
+const redisUnLockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+func (s *redisStore) UnLock(key string, value string) error {
+	if _, err := s.client.do("EVAL", redisUnLockScript, "1", s.prefix+"lock:"+key, value); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (s *redisStore) AddNonce(key string, expire time.Duration) (bool, error) {
+	reply, err := s.client.do("SET", s.prefix+"nonce:"+key, "1", "NX", "PX",
+		strconv.FormatInt(expire.Milliseconds(), 10))
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+	return reply != nil, nil
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// fakeRedis 监听本地端口的RESP服务 只实现redisStore用到的命令
+// SCAN每页固定返回fakeRedisScanPage个key 且与redis一样可能返回已过期但未清理的key
+type fakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	values   map[string]fakeRedisValue
+	commands map[string]int
+}
+
+type fakeRedisValue struct {
+	value    string
+	expireAt time.Time
+}
+
+const fakeRedisScanPage = 2
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{
+		listener: listener,
+		values:   make(map[string]fakeRedisValue),
+		commands: make(map[string]int),
+	}
+	go r.serve()
+	t.Cleanup(func() { listener.Close() })
+	return r
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serveConn(conn)
+	}
+}
+
+func (r *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err = io.WriteString(conn, r.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		args[i] = string(value[:size])
+	}
+	return args, nil
+}
+
+func fakeRedisBulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// get 读取未过期的值 调用方持有锁
+func (r *fakeRedis) get(key string) (string, bool) {
+	v, ok := r.values[key]
+	if !ok || (!v.expireAt.IsZero() && time.Now().After(v.expireAt)) {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (r *fakeRedis) exec(args []string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cmd := strings.ToUpper(args[0])
+	r.commands[cmd]++
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if value, ok := r.get(args[1]); ok {
+			return fakeRedisBulk(value)
+		}
+		return "$-1\r\n"
+	case "SET":
+		v := fakeRedisValue{value: args[2]}
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				ms, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || ms <= 0 {
+					return "-ERR invalid expire time in 'set' command\r\n"
+				}
+				v.expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		if _, ok := r.get(args[1]); ok && nx {
+			return "$-1\r\n"
+		}
+		r.values[args[1]] = v
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := r.get(key); ok {
+				n++
+			}
+			delete(r.values, key)
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if value, ok := r.get(key); ok {
+				reply += fakeRedisBulk(value)
+			} else {
+				reply += "$-1\r\n"
+			}
+		}
+		return reply
+	case "SCAN":
+		cursor, _ := strconv.Atoi(args[1])
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		keys := make([]string, 0, len(r.values))
+		for key := range r.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		end := cursor + fakeRedisScanPage
+		next := strconv.Itoa(end)
+		if end >= len(keys) {
+			end, next = len(keys), "0"
+		}
+		var matched []string
+		for _, key := range keys[cursor:end] {
+			if ok, _ := path.Match(pattern, key); ok {
+				matched = append(matched, key)
+			}
+		}
+		reply := "*2\r\n" + fakeRedisBulk(next) + fmt.Sprintf("*%d\r\n", len(matched))
+		for _, key := range matched {
+			reply += fakeRedisBulk(key)
+		}
+		return reply
+	case "EVAL":
+		if args[1] != redisUnLockScript || args[2] != "1" {
+			return "-ERR unknown script\r\n"
+		}
+		if value, ok := r.get(args[3]); ok && value == args[4] {
+			delete(r.values, args[3])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+// set 直接写入 不经过命令
+func (r *fakeRedis) set(key string, v fakeRedisValue) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[key] = v
+}
+
+func (r *fakeRedis) commandCount(cmd string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.commands[cmd]
+}
+
+func newTestRedisStore(t *testing.T) (*redisStore, *fakeRedis) {
+	r := newFakeRedis(t)
+	s, err := NewRedisStore(&config.Store{
+		RedisAddr:    r.listener.Addr().String(),
+		RedisPrefix:  "wxcomponent:",
+		RedisTimeout: 1000,
+		RedisMaxIdle: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*redisStore), r
+}
+
+func TestRedisStoreScanPaging(t *testing.T) {
+	s, r := newTestRedisStore(t)
+	for i := 0; i < 5; i++ {
+		if err := s.SetToken(&model.WxToken{Type: model.WXTOKENTYPE_AUTH, Appid: fmt.Sprintf("wxappid%d", i),
+			Token: "token", Expiretime: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 不匹配前缀的key和锁不应出现在列表中
+	r.set("other:token:1:wxother", fakeRedisValue{value: "{}"})
+	if err := s.Lock("wxappid0", "owner", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.ListTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("len(records) = %d, want 5", len(records))
+	}
+	for i, v := range records {
+		if v.Appid != fmt.Sprintf("wxappid%d", i) || v.Token != "" {
+			t.Errorf("records[%d] = %+v", i, v)
+		}
+	}
+	if n := r.commandCount("SCAN"); n < 4 {
+		t.Errorf("SCAN called %d times, want paging", n)
+	}
+}
+
+func TestRedisStoreNilReply(t *testing.T) {
+	s, r := newTestRedisStore(t)
+	if _, found, err := s.GetToken("wxappid", model.WXTOKENTYPE_AUTH); err != nil || found {
+		t.Fatalf("GetToken found %v, err %v", found, err)
+	}
+	if ticket, err := s.GetTicket(); err != nil || ticket != "" {
+		t.Fatalf("GetTicket = %q, err %v", ticket, err)
+	}
+	// SCAN返回的key在MGET时已过期 MGET对应位置为nil
+	r.set(s.tokenKey("wxexpired", model.WXTOKENTYPE_AUTH), fakeRedisValue{value: "{}",
+		expireAt: time.Now().Add(-time.Second)})
+	records, err := s.ListTokens()
+	if err != nil || len(records) != 0 {
+		t.Fatalf("ListTokens = %v, err %v", records, err)
+	}
+}
+
+func TestRedisStoreError(t *testing.T) {
+	s, _ := newTestRedisStore(t)
+	// redis返回的错误不影响连接复用
+	if _, err := s.client.do("UNKNOWN"); err == nil {
+		t.Fatal("want redis error")
+	} else if _, ok := err.(redisError); !ok {
+		t.Fatalf("err = %v, want redisError", err)
+	}
+	if err := s.SetTicket("ticket"); err != nil {
+		t.Fatal(err)
+	}
+	if ticket, err := s.GetTicket(); err != nil || ticket != "ticket" {
+		t.Fatalf("GetTicket = %q, err %v", ticket, err)
+	}
+}
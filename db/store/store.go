@@ -0,0 +1,65 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// Store token、ticket、分布式锁和防重放随机串的存储 通过server.conf的[store]选择实现
+type Store interface {
+	// GetToken 获取token 不存在时found为false
+	GetToken(appid string, tokenType int) (record *model.WxToken, found bool, err error)
+	// SetToken 创建或更新token
+	SetToken(record *model.WxToken) error
+	// DelToken 删除token 只删除内容相同的记录 避免删除其他实例刚刷新的token
+	DelToken(appid string, tokenType int, token string) error
+	// ListTokens 获取所有token的过期时间 不包含token内容
+	ListTokens() ([]*model.WxToken, error)
+	// GetTicket 获取最新的component_verify_ticket 不存在时为空
+	GetTicket() (string, error)
+	// SetTicket 更新component_verify_ticket
+	SetTicket(ticket string) error
+	// Lock 申请锁 已被持有且未超过expire时返回错误
+	Lock(key string, value string, expire time.Duration) error
+	// UnLock 释放自己持有的锁 value与加锁时不同时不释放 避免锁过期后释放其他实例持有的锁
+	UnLock(key string, value string) error
+	// AddNonce 记录一次性的随机串 expire内已记录过时added为false 用于防止请求重放
+	AddNonce(key string, expire time.Duration) (added bool, err error)
+}
+
+// 存储类型
+const (
+	STORETYPE_MYSQL  = "mysql"
+	STORETYPE_MEMORY = "memory"
+	STORETYPE_REDIS  = "redis"
+)
+
+var current Store = NewMysqlStore()
+
+// Init 按配置初始化存储 需在db.Init之后调用
+func Init() error {
+	var err error
+	switch config.StoreConf.Type {
+	case "", STORETYPE_MYSQL:
+		current = NewMysqlStore()
+	case STORETYPE_MEMORY:
+		current = NewMemoryStore()
+	case STORETYPE_REDIS:
+		if current, err = NewRedisStore(config.StoreConf); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid store type: %s", config.StoreConf.Type)
+	}
+	log.Infof("store type: %s", config.StoreConf.Type)
+	return nil
+}
+
+// Get 获取当前的存储
+func Get() Store {
+	return current
+}
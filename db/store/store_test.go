@@ -0,0 +1,158 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+)
+
+// 各存储实现需满足的约定 mysql存储依赖数据库 不在此测试
+func testStores(t *testing.T, f func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryStore())
+	})
+	t.Run("redis", func(t *testing.T) {
+		s, _ := newTestRedisStore(t)
+		f(t, s)
+	})
+}
+
+func TestStoreLock(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if err := s.Lock("key", "owner1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Lock("key", "owner2", time.Minute); err == nil {
+			t.Fatal("lock held by owner1 acquired by owner2")
+		}
+		// 其他持有者释放时不删除锁
+		if err := s.UnLock("key", "owner2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Lock("key", "owner2", time.Minute); err == nil {
+			t.Fatal("lock released by owner2")
+		}
+		if err := s.UnLock("key", "owner1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Lock("key", "owner2", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Lock("other", "owner1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStoreLockExpire(t *testing.T) {
+	// 调用方对同一个锁使用相同的expire
+	const expire = 200 * time.Millisecond
+	testStores(t, func(t *testing.T, s Store) {
+		if err := s.Lock("key", "owner1", expire); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(expire + 100*time.Millisecond)
+		if err := s.Lock("key", "owner2", expire); err != nil {
+			t.Fatalf("expired lock not acquired: %v", err)
+		}
+		// 过期的持有者释放时不影响新的持有者
+		if err := s.UnLock("key", "owner1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Lock("key", "owner3", expire); err == nil {
+			t.Fatal("lock released by expired owner")
+		}
+	})
+}
+
+func TestStoreDelToken(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		record := &model.WxToken{Type: model.WXTOKENTYPE_AUTH, Appid: "wxappid", Token: "token1",
+			Expiretime: time.Now().Add(time.Hour)}
+		if err := s.SetToken(record); err != nil {
+			t.Fatal(err)
+		}
+		// 内容不同时不删除
+		if err := s.DelToken("wxappid", model.WXTOKENTYPE_AUTH, "token0"); err != nil {
+			t.Fatal(err)
+		}
+		got, found, err := s.GetToken("wxappid", model.WXTOKENTYPE_AUTH)
+		if err != nil || !found || got.Token != "token1" {
+			t.Fatalf("GetToken = %+v, found %v, err %v", got, found, err)
+		}
+		if err := s.DelToken("wxappid", model.WXTOKENTYPE_AUTH, "token1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, found, err = s.GetToken("wxappid", model.WXTOKENTYPE_AUTH); err != nil || found {
+			t.Fatalf("GetToken after DelToken found %v, err %v", found, err)
+		}
+		// 不存在时不报错
+		if err := s.DelToken("wxappid", model.WXTOKENTYPE_AUTH, "token1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStoreTokenAndTicket(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if _, found, err := s.GetToken("wxappid", model.WXTOKENTYPE_AUTH); err != nil || found {
+			t.Fatalf("GetToken found %v, err %v", found, err)
+		}
+		if ticket, err := s.GetTicket(); err != nil || ticket != "" {
+			t.Fatalf("GetTicket = %q, err %v", ticket, err)
+		}
+		if err := s.SetTicket("ticket"); err != nil {
+			t.Fatal(err)
+		}
+		if ticket, err := s.GetTicket(); err != nil || ticket != "ticket" {
+			t.Fatalf("GetTicket = %q, err %v", ticket, err)
+		}
+
+		expire := time.Now().Add(time.Hour).Truncate(time.Second)
+		for _, v := range []*model.WxToken{
+			{Type: model.WXTOKENTYPE_AUTH, Appid: "wxappid2", Token: "token2", Expiretime: expire},
+			{Type: model.WXTOKENTYPE_AUTH, Appid: "wxappid1", Token: "token1", Expiretime: expire},
+			{Type: model.WXTOKENTYPE_OWN, Appid: "wxcomponent", Token: "token0", Expiretime: expire},
+		} {
+			if err := s.SetToken(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		records, err := s.ListTokens()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("len(records) = %d, want 3", len(records))
+		}
+		for _, v := range records {
+			if v.Token != "" || !v.Expiretime.Equal(expire) {
+				t.Errorf("unexpected record %+v", v)
+			}
+		}
+		if records[1].Appid != "wxappid1" || records[2].Appid != "wxappid2" {
+			t.Errorf("records not sorted: %s %s", records[1].Appid, records[2].Appid)
+		}
+	})
+}
+
+func TestStoreAddNonce(t *testing.T) {
+	const expire = 200 * time.Millisecond
+	testStores(t, func(t *testing.T, s Store) {
+		if added, err := s.AddNonce("nonce1", expire); err != nil || !added {
+			t.Fatalf("AddNonce added %v, err %v", added, err)
+		}
+		if added, err := s.AddNonce("nonce1", expire); err != nil || added {
+			t.Fatalf("reused nonce added %v, err %v", added, err)
+		}
+		if added, err := s.AddNonce("nonce2", expire); err != nil || !added {
+			t.Fatalf("AddNonce added %v, err %v", added, err)
+		}
+		// 过期后可以再次记录
+		time.Sleep(expire + 100*time.Millisecond)
+		if added, err := s.AddNonce("nonce1", expire); err != nil || !added {
+			t.Fatalf("expired nonce added %v, err %v", added, err)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/inits"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/routers"
@@ -9,6 +11,11 @@ import (
 )
 
 func main() {
+	flag.Parse()
+	if runConfigCli() {
+		return
+	}
+
 	log.Infof("system begin")
 	if err := inits.Init(); err != nil {
 		log.Errorf("inits failed, err:%v", err)
@@ -22,7 +29,7 @@ func main() {
 	g.Go(func() error {
 		r := routers.InnerServiceInit()
 		if err := r.Run("127.0.0.1:8081"); err != nil {
-			log.Error("startup inner service failed, err:%v", err)
+			log.Errorf("startup inner service failed, err:%v", err)
 			return err
 		}
 		return nil
@@ -32,7 +39,7 @@ func main() {
 	g.Go(func() error {
 		r := routers.Init()
 		if err := r.Run(":80"); err != nil {
-			log.Error("startup service failed, err:%v", err)
+			log.Errorf("startup service failed, err:%v", err)
 			return err
 		}
 		return nil
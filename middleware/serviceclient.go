@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/dao"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/model"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/db/store"
+	"github.com/gin-gonic/gin"
+)
+
+// 内部服务客户端凭证 通过Authorization头传递 两种方式任选其一
+// Bearer: Authorization: Bearer <name>:<secret>
+// HMAC: Authorization: HMAC-SHA256 Client=<name>, Timestamp=<秒级时间戳>, Nonce=<随机串>, Signature=<签名>
+// 签名为以secret为key对"METHOD\nPATH\nQUERY\nTIMESTAMP\nNONCE"做HMAC-SHA256的十六进制小写结果
+
+const serviceClientKey = "service_client"
+
+// hmacMaxSkew 签名时间戳允许的最大偏差 同一nonce在该时间内不能重复使用
+const hmacMaxSkew = 5 * time.Minute
+
+// authServiceClient 校验客户端凭证 没有凭证时都返回nil
+func authServiceClient(c *gin.Context) (*model.ServiceClient, errno.Result) {
+	auth := c.Request.Header.Get("Authorization")
+	scheme, credential := auth, ""
+	if i := strings.Index(auth, " "); i != -1 {
+		scheme, credential = auth[:i], strings.TrimSpace(auth[i+1:])
+	}
+	var name, nonce string
+	var check func(client *model.ServiceClient) bool
+	switch scheme {
+	case "Bearer":
+		i := strings.Index(credential, ":")
+		if i == -1 {
+			return nil, errno.ErrNotAuthorized.WithData("凭证格式有误")
+		}
+		name = credential[:i]
+		secret := credential[i+1:]
+		check = func(client *model.ServiceClient) bool {
+			return hmac.Equal([]byte(client.Secret), []byte(secret))
+		}
+	case "HMAC-SHA256":
+		params := make(map[string]string)
+		for _, v := range strings.Split(credential, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(v), "=", 2); len(kv) == 2 {
+				params[kv[0]] = kv[1]
+			}
+		}
+		name = params["Client"]
+		timestamp, err := strconv.ParseInt(params["Timestamp"], 10, 64)
+		if err != nil || params["Nonce"] == "" || params["Signature"] == "" {
+			return nil, errno.ErrNotAuthorized.WithData("凭证格式有误")
+		}
+		if d := time.Since(time.Unix(timestamp, 0)); d > hmacMaxSkew || d < -hmacMaxSkew {
+			return nil, errno.ErrNotAuthorized.WithData("时间戳已过期")
+		}
+		nonce = params["Nonce"]
+		check = func(client *model.ServiceClient) bool {
+			mac := hmac.New(sha256.New, []byte(client.Secret))
+			mac.Write([]byte(strings.Join([]string{c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery,
+				params["Timestamp"], params["Nonce"]}, "\n")))
+			signature, err := hex.DecodeString(params["Signature"])
+			return err == nil && hmac.Equal(mac.Sum(nil), signature)
+		}
+	default:
+		return nil, nil
+	}
+
+	client, err := dao.GetServiceClientWithCache(name)
+	if err != nil {
+		return nil, errno.ErrSystemError.WithData(err.Error())
+	}
+	if client == nil || !check(client) {
+		log.Infof("service client auth fail: %s", name)
+		return nil, errno.ErrNotAuthorized.WithData("客户端或凭证有误")
+	}
+	if client.Open == 0 || client.Expired() {
+		return nil, errno.ErrNotAuthorized.WithData("客户端已停用或已过期")
+	}
+	// 签名正确后才记录nonce 避免未授权的请求占用 记录在共享存储中 多实例部署时同样不能重放
+	if nonce != "" {
+		added, err := store.Get().AddNonce(genServiceClientNonceKey(client.Name, nonce), 2*hmacMaxSkew)
+		if err != nil {
+			return nil, errno.ErrSystemError.WithData(err.Error())
+		}
+		if !added {
+			log.Infof("service client nonce reused: %s", name)
+			return nil, errno.ErrNotAuthorized.WithData("nonce已使用")
+		}
+	}
+	return client, nil
+}
+
+// genServiceClientNonceKey 客户端名和nonce长度不定 取摘要作为存储的key
+func genServiceClientNonceKey(name string, nonce string) string {
+	sum := sha1.Sum([]byte(name + "\n" + nonce))
+	return "service_client_" + hex.EncodeToString(sum[:])
+}
+
+// InnerServiceClientMiddleWare 内部服务的客户端认证 带凭证时校验凭证 否则按白名单校验
+// 配置了RequireClient时必须使用客户端凭证
+func InnerServiceClientMiddleWare(c *gin.Context) {
+	client, res := authServiceClient(c)
+	if res != nil {
+		c.Abort()
+		c.JSON(http.StatusUnauthorized, res)
+		return
+	}
+	if client != nil {
+		c.Set(serviceClientKey, client)
+		c.Next()
+		return
+	}
+	if config.InnerServiceConf.RequireClient {
+		c.Abort()
+		c.JSON(http.StatusUnauthorized, errno.ErrNotAuthorized.WithData("缺少客户端凭证"))
+		return
+	}
+	InnerServiceMiddleWare(c)
+}
+
+// ServiceTokenMiddleWare 按客户端的权限检查可获取的凭证 管理员登录时不限制
+// 通过白名单或apikey访问时只在未配置RequireClient时放行
+func ServiceTokenMiddleWare(tokenType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := c.Get(serviceClientKey); ok {
+			client := value.(*model.ServiceClient)
+			if !client.AllowToken(tokenType, c.Query("appid")) {
+				log.Infof("service client %s has no permission for %s %s", client.Name, tokenType, c.Query("appid"))
+				c.Abort()
+				c.JSON(http.StatusForbidden, errno.ErrNoPermission)
+				return
+			}
+			c.Next()
+			return
+		}
+		if _, ok := c.Get("jwt"); !ok && config.InnerServiceConf.RequireClient {
+			c.Abort()
+			c.JSON(http.StatusUnauthorized, errno.ErrNotAuthorized.WithData("缺少客户端凭证"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -4,16 +4,23 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/errno"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/wx/msgcrypt"
 
 	"github.com/gin-gonic/gin"
 )
 
 // WXSourceMiddleWare 中间件 判断是否来源于微信
 func WXSourceMiddleWare(c *gin.Context) {
-	if _, ok := c.Request.Header[http.CanonicalHeaderKey("x-wx-source")]; ok {
+	if _, ok := c.Request.Header[http.CanonicalHeaderKey("x-wx-source")]; ok && config.WxCallbackConf.TrustSourceHeader {
 		fmt.Println("[WXSourceMiddleWare]from wx")
 		c.Next()
+	} else if msgcrypt.VerifyURL(config.WxCallbackConf.Token, c.Query("signature"),
+		c.Query("timestamp"), c.Query("nonce")) {
+		log.Debug("[WXSourceMiddleWare]signature verified")
+		c.Next()
 	} else {
 		c.Abort()
 		c.JSON(http.StatusUnauthorized, errno.ErrNotAuthorized)
@@ -8,6 +8,8 @@ import (
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/innerservice"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/proxy"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/api/wxcallback"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/config"
+	"github.com/WeixinCloud/wxcloudrun-wxcomponent/comm/log"
 	"github.com/WeixinCloud/wxcloudrun-wxcomponent/middleware"
 	"github.com/gin-gonic/gin"
 )
@@ -48,6 +50,9 @@ func Init() *gin.Engine {
 
 // InnerServiceInit 内部服务初始化
 func InnerServiceInit() *gin.Engine {
+	if !config.InnerServiceConf.RequireClient {
+		log.Error("[innerservice] RequireClient is false, token apis accept requests without client credentials")
+	}
 	r := gin.Default()
 	r.Use(middleware.LogMiddleWare)
 	innerservice.Routers(r)